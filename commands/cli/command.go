@@ -1,6 +1,9 @@
 package cli
 
-import "github.com/spf13/cobra"
+import (
+	"github.com/spf13/cobra"
+	"go.uber.org/fx"
+)
 
 type ICommandRunner interface{}
 
@@ -33,3 +36,9 @@ type ICommand interface {
 	//
 	Run() ICommandRunner
 }
+
+// ICommandOptions is implemented by the commands that need extra fx options,
+// such as values supplied to the constructors.
+type ICommandOptions interface {
+	Options() fx.Option
+}
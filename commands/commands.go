@@ -2,10 +2,13 @@ package commands
 
 import (
 	"apple-findmy-to-mqtt/commands/cli"
-	"apple-findmy-to-mqtt/infrastructure/config"
 	"apple-findmy-to-mqtt/infrastructure/logging"
 	"context"
 	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 	"go.uber.org/fx"
@@ -13,7 +16,8 @@ import (
 )
 
 var cmds = map[string]cli.ICommand{
-	"scan": NewScanCommand(),
+	"purge": NewPurgeCommand(),
+	"scan":  NewScanCommand(),
 }
 
 // get a list of sub commands
@@ -23,6 +27,7 @@ func GetSubCommands(opt fx.Option) []*cobra.Command {
 	for name, cmd := range cmds {
 		subCmds = append(subCmds, wrapSubCommand(name, cmd, opt))
 	}
+	subCmds = append(subCmds, NewConfigCommand())
 
 	return subCmds
 }
@@ -33,24 +38,45 @@ func wrapSubCommand(name string, cmd cli.ICommand, opt fx.Option) *cobra.Command
 		Use:   name,
 		Short: cmd.Short(),
 		Run: func(c *cobra.Command, args []string) {
-			serverCommandwrapper, _ := cmd.GetFlags().(*ScanCommandWrapper)
-			if err := config.SetupConfig(serverCommandwrapper.Path); err != nil {
-				panic(fmt.Sprintf("%s | %s", names, err))
+			var envPath string
+			switch flags := cmd.GetFlags().(type) {
+			case *PurgeCommandWrapper:
+				envPath = flags.Path
+			case *ScanCommandWrapper:
+				envPath = flags.Path
 			}
+			cfg := loadConfig(envPath)
 			logger := logging.GetLogger()
 			opts := fx.Options(
 				fx.WithLogger(func() fxevent.Logger {
 					return logger.GetFxLogger()
 				}),
+				fx.StopTimeout(time.Duration(cfg.ShutdownTimeout)*time.Second),
 				fx.Invoke(cmd.Run()),
 			)
+			if withOptions, ok := cmd.(cli.ICommandOptions); ok {
+				opts = fx.Options(opts, withOptions.Options())
+			}
 			ctx := context.Background()
 			app := fx.New(opt, opts)
 			if err := app.Start(ctx); err != nil {
 				logger.Fatal(fmt.Sprintf("%s | %s", names, err))
 				panic(fmt.Sprintf("%s | %s", names, err))
 			}
-			if err := app.Stop(ctx); err != nil {
+			// fx relays SIGINT and SIGTERM, as well as the shutdowner, to Wait.
+			<-app.Wait()
+			logger.Info(fmt.Sprintf("%s | Stopping, waiting up to %s for the running work", names, app.StopTimeout()))
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+			defer signal.Stop(signals)
+			go func() {
+				sig := <-signals
+				logger.Warn(fmt.Sprintf("%s | Received %s while stopping, exiting now", names, sig))
+				os.Exit(1)
+			}()
+			stopCtx, cancel := context.WithTimeout(ctx, app.StopTimeout())
+			defer cancel()
+			if err := app.Stop(stopCtx); err != nil {
 				logger.Fatal(fmt.Sprintf("%s | %s", names, err))
 				panic(fmt.Sprintf("%s | %s", names, err))
 			}
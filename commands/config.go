@@ -0,0 +1,46 @@
+package commands
+
+import (
+	"apple-findmy-to-mqtt/infrastructure/config"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// NewConfigCommand returns the config command, which works on the
+// configuration alone and does not start the application.
+func NewConfigCommand() *cobra.Command {
+	var envPath string
+	validateCmd := &cobra.Command{
+		Use:   "validate",
+		Short: "check the configuration and report every problem found",
+		Run: func(c *cobra.Command, args []string) {
+			loadConfig(envPath)
+			fmt.Println("Configuration is valid")
+		},
+	}
+	validateCmd.Flags().StringVarP(&envPath, "env", "e", "", "Specify the .env file(s).")
+
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "inspect the configuration",
+	}
+	configCmd.AddCommand(validateCmd)
+	return configCmd
+}
+
+// loadConfig loads and validates the configuration, exiting with the report
+// of the problems found instead of a stack trace.
+func loadConfig(envPath string) config.Config {
+	if err := config.SetupConfig(envPath); err != nil {
+		fmt.Fprintf(os.Stderr, "%s, use --env\n", err)
+		os.Exit(1)
+	}
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	return cfg
+}
@@ -0,0 +1,78 @@
+package commands
+
+import (
+	"apple-findmy-to-mqtt/commands/cli"
+	"apple-findmy-to-mqtt/core/interfaces"
+	"apple-findmy-to-mqtt/infrastructure/logging"
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/fx"
+)
+
+type PurgeCommand struct {
+	envPath string
+}
+
+type PurgeCommandWrapper struct {
+	Path string
+}
+
+// create a new purge command
+func NewPurgeCommand() *PurgeCommand {
+	return &PurgeCommand{}
+}
+
+func (pC *PurgeCommand) Short() string {
+	return "remove every device and retained message published by the bridge"
+}
+
+func (pC *PurgeCommand) Setup(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&pC.envPath, "env", "e", "", "Specify the .env file(s).")
+}
+
+func (pC *PurgeCommand) GetFlags() interface{} {
+	return &PurgeCommandWrapper{Path: pC.envPath}
+}
+
+// Options makes the MQTT client passive, so that purging does not disturb a
+// running bridge nor republish its offline queue.
+func (pC *PurgeCommand) Options() fx.Option {
+	return fx.Supply(interfaces.MQTTClientOptions{
+		ClientIDSuffix: "-purge",
+		Passive:        true,
+	})
+}
+
+func (pC *PurgeCommand) Run() cli.ICommandRunner {
+	const names = "__purge.go__: Run"
+	return func(
+		lifecycle fx.Lifecycle,
+		logger logging.Logger,
+		purgeMQTTController interfaces.IPurgeMQTTController,
+		shutdowner fx.Shutdowner,
+	) {
+		ctx, cancel := context.WithCancel(context.Background())
+		lifecycle.Append(fx.Hook{
+			OnStart: func(context.Context) error {
+				go func() {
+					purged, err := purgeMQTTController.Purge(ctx)
+					if err != nil {
+						logger.Error(fmt.Sprintf("%s | %s", names, err.Error()))
+					} else {
+						logger.Info(fmt.Sprintf("%s | Cleared %d topics", names, purged))
+					}
+					if err := shutdowner.Shutdown(); err != nil {
+						logger.Error(fmt.Sprintf("%s | %s", names, err.Error()))
+					}
+				}()
+				return nil
+			},
+			OnStop: func(context.Context) error {
+				cancel()
+				return nil
+			},
+		})
+	}
+}
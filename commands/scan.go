@@ -5,10 +5,13 @@ import (
 	"apple-findmy-to-mqtt/core/interfaces"
 	"apple-findmy-to-mqtt/infrastructure/config"
 	"apple-findmy-to-mqtt/infrastructure/logging"
+	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/spf13/cobra"
+	"go.uber.org/fx"
 )
 
 type ScanCommand struct {
@@ -40,17 +43,110 @@ func (sC *ScanCommand) Run() cli.ICommandRunner {
 	const names = "__scan.go__: Run"
 	return func(
 		cacheSyncMQTTController interfaces.ICacheSyncMQTTController,
-		config config.Config,
+		cacheWatcher interfaces.ICacheWatcher,
+		cfg config.Config,
+		commandMQTTController interfaces.ICommandMQTTController,
+		knownLocationsUsecase interfaces.IKnownLocationsUsecase,
+		lifecycle fx.Lifecycle,
 		logger logging.Logger,
 	) {
-		loc, _ := time.LoadLocation(config.TZ)
+		loc, _ := time.LoadLocation(cfg.TZ)
 		time.Local = loc
-		logger.Info(fmt.Sprintf("%s | %s", names, "Starting the scan ..."))
-		ticker := time.NewTicker(time.Duration(config.ScanTimer) * time.Second)
-		defer ticker.Stop()
-		for range ticker.C {
+		// Cancelled on stop, which interrupts the scan in progress once its
+		// in-flight publishes are done.
+		ctx, cancel := context.WithCancel(context.Background())
+		knownLocationsUsecase.OnReload(func() {
+			logger.Info(fmt.Sprintf("%s | %s", names, "Known locations reloaded, republishing every device"))
+			process(ctx, cacheSyncMQTTController, true, logger)
+		})
+		if err := commandMQTTController.Subscribe(); err != nil {
+			logger.Error(fmt.Sprintf("%s | %s", names, err.Error()))
+		}
+		done := make(chan struct{})
+		lifecycle.Append(fx.Hook{
+			OnStart: func(context.Context) error {
+				logger.Info(fmt.Sprintf("%s | %s", names, "Starting the scan ..."))
+				go func() {
+					defer close(done)
+					scan(ctx, cacheSyncMQTTController, cacheWatcher, cfg, logger)
+				}()
+				return nil
+			},
+			OnStop: func(stopCtx context.Context) error {
+				logger.Info(fmt.Sprintf("%s | %s", names, "Stopping the scan ..."))
+				cancel()
+				select {
+				case <-done:
+					return nil
+				case <-stopCtx.Done():
+					return stopCtx.Err()
+				}
+			},
+		})
+	}
+}
+
+// scan processes the cache on every change in watch and poll modes, or every
+// scan_timer seconds otherwise, until ctx is done. In watch and poll modes, a
+// housekeeping scan also runs on housekeepingInterval, since the HTTP push and
+// replay sources, the heartbeat and the timeouts do not change the cache files.
+func scan(ctx context.Context, cacheSyncMQTTController interfaces.ICacheSyncMQTTController, cacheWatcher interfaces.ICacheWatcher, cfg config.Config, logger logging.Logger) {
+	const names = "__scan.go__: scan"
+	if cfg.ScanMode == config.ScanModeWatch || cfg.ScanMode == config.ScanModePoll {
+		logger.Info(fmt.Sprintf("%s | Running initial scan", names))
+		process(ctx, cacheSyncMQTTController, cfg.ForceSync, logger)
+		var wg sync.WaitGroup
+		defer wg.Wait()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tick(ctx, cacheSyncMQTTController, housekeepingInterval(cfg), cfg.ForceSync, logger)
+		}()
+		err := cacheWatcher.Watch(ctx, func() {
+			logger.Info(fmt.Sprintf("%s | %s", names, "Running scan"))
+			process(ctx, cacheSyncMQTTController, cfg.ForceSync, logger)
+		})
+		if err != nil {
+			logger.Error(fmt.Sprintf("%s | %s", names, err.Error()))
+		}
+		return
+	}
+	tick(ctx, cacheSyncMQTTController, time.Duration(cfg.ScanTimer)*time.Second, cfg.ForceSync, logger)
+}
+
+// housekeepingInterval returns the smallest of scan_timer, publish_heartbeat
+// and device_availability_timeout, the disabled ones aside, so that the
+// heartbeats, the availability, the zone expiry, the dwell and the cleanup are
+// not held up by a cache that does not change.
+func housekeepingInterval(cfg config.Config) time.Duration {
+	interval := cfg.ScanTimer
+	for _, seconds := range []int{cfg.PublishHeartbeat, cfg.DeviceAvailabilityTimeout} {
+		if seconds > 0 && seconds < interval {
+			interval = seconds
+		}
+	}
+	return time.Duration(interval) * time.Second
+}
+
+// tick processes the sources every interval until ctx is done.
+func tick(ctx context.Context, cacheSyncMQTTController interfaces.ICacheSyncMQTTController, interval time.Duration, forceSync bool, logger logging.Logger) {
+	const names = "__scan.go__: tick"
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 			logger.Info(fmt.Sprintf("%s | %s", names, "Running scan"))
-			cacheSyncMQTTController.Process(config.ForceSync)
+			process(ctx, cacheSyncMQTTController, forceSync, logger)
 		}
 	}
 }
+
+func process(ctx context.Context, cacheSyncMQTTController interfaces.ICacheSyncMQTTController, forceSync bool, logger logging.Logger) {
+	const names = "__scan.go__: process"
+	if _, err := cacheSyncMQTTController.Process(ctx, forceSync); err != nil {
+		logger.Warn(fmt.Sprintf("%s | %s", names, err.Error()))
+	}
+}
@@ -0,0 +1,86 @@
+package commands
+
+import (
+	"apple-findmy-to-mqtt/core/entities"
+	"apple-findmy-to-mqtt/core/interfaces"
+	"apple-findmy-to-mqtt/infrastructure/config"
+	"apple-findmy-to-mqtt/infrastructure/logging"
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type fakeCacheSyncMQTTController struct {
+	interfaces.ICacheSyncMQTTController
+	processed chan bool
+}
+
+func (f *fakeCacheSyncMQTTController) Process(ctx context.Context, forceSync bool) (entities.ScanResult, error) {
+	f.processed <- forceSync
+	return entities.ScanResult{}, nil
+}
+
+type fakeCacheWatcher struct {
+	interfaces.ICacheWatcher
+}
+
+// Watch never reports a change, like a cache that is not rewritten.
+func (f *fakeCacheWatcher) Watch(ctx context.Context, onChange func()) error {
+	<-ctx.Done()
+	return nil
+}
+
+func TestHousekeepingInterval(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.Config
+		want time.Duration
+	}{
+		{name: "scan timer", cfg: config.Config{ScanTimer: 5, PublishHeartbeat: 3600}, want: 5 * time.Second},
+		{name: "heartbeat", cfg: config.Config{ScanTimer: 600, PublishHeartbeat: 60}, want: 60 * time.Second},
+		{name: "availability timeout", cfg: config.Config{ScanTimer: 600, PublishHeartbeat: 3600, DeviceAvailabilityTimeout: 300}, want: 300 * time.Second},
+		{name: "disabled", cfg: config.Config{ScanTimer: 600}, want: 600 * time.Second},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if got := housekeepingInterval(tt.cfg); got != tt.want {
+				t.Errorf("housekeepingInterval() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanHousekeeping(t *testing.T) {
+	for _, scanMode := range []string{config.ScanModeWatch, config.ScanModePoll} {
+		scanMode := scanMode
+		t.Run(scanMode, func(t *testing.T) {
+			controller := &fakeCacheSyncMQTTController{processed: make(chan bool)}
+			cfg := config.Config{ScanMode: scanMode, ScanTimer: 1}
+			logger := logging.Logger{SugaredLogger: zap.NewNop().Sugar()}
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				scan(ctx, controller, &fakeCacheWatcher{}, cfg, logger)
+			}()
+
+			// The initial scan, then a housekeeping scan without any change.
+			for i := 0; i < 2; i++ {
+				select {
+				case <-controller.processed:
+				case <-time.After(3 * time.Second):
+					t.Fatalf("scan %d did not run", i+1)
+				}
+			}
+			cancel()
+			go func() {
+				for range controller.processed {
+				}
+			}()
+			<-done
+		})
+	}
+}
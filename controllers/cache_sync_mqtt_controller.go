@@ -5,147 +5,527 @@ import (
 	"apple-findmy-to-mqtt/core/interfaces"
 	"apple-findmy-to-mqtt/infrastructure/config"
 	"apple-findmy-to-mqtt/infrastructure/logging"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"go.uber.org/fx"
 )
 
-type DeviceConfig struct {
-	UniqueID            string `json:"unique_id"`
-	StateTopic          string `json:"state_topic"`
-	JSONAttributesTopic string `json:"json_attributes_topic"`
-	Device              struct {
-		Identifiers  string `json:"identifiers"`
-		Manufacturer string `json:"manufacturer"`
-		Name         string `json:"name"`
-		Mdl          string `json:"mdl"`
-	} `json:"device"`
-	SourceType     string `json:"source_type"`
-	PayloadHome    string `json:"payload_home"`
-	PayloadNotHome string `json:"payload_not_home"`
+type DeviceAttributes struct {
+	Latitude              float64                        `json:"latitude"`
+	Longitude             float64                        `json:"longitude"`
+	Altitude              float64                        `json:"altitude"`
+	FloorLevel            int                            `json:"floor_level"`
+	GPSAccuracy           float64                        `json:"gps_accuracy"`
+	IsOld                 bool                           `json:"is_old"`
+	IsInaccurate          bool                           `json:"is_inaccurate"`
+	Address               string                         `json:"address"`
+	StreetAddress         string                         `json:"street_address,omitempty"`
+	PostalCode            string                         `json:"postal_code,omitempty"`
+	Locality              string                         `json:"locality,omitempty"`
+	SubAdministrativeArea string                         `json:"sub_administrative_area,omitempty"`
+	AdministrativeArea    string                         `json:"administrative_area,omitempty"`
+	StateCode             string                         `json:"state_code,omitempty"`
+	Country               string                         `json:"country,omitempty"`
+	CountryCode           string                         `json:"country_code,omitempty"`
+	BatteryStatus         string                         `json:"batteryStatus"`
+	BatteryLevel          *float64                       `json:"battery_level,omitempty"`
+	LowPowerMode          bool                           `json:"low_power_mode"`
+	ProductType           string                         `json:"product_type,omitempty"`
+	Role                  string                         `json:"role,omitempty"`
+	RoleEmoji             string                         `json:"role_emoji,omitempty"`
+	PartInfo              map[string]any                 `json:"part_info,omitempty"`
+	CrowdSourcedLocation  *DeviceAttributesLocation      `json:"crowd_sourced_location,omitempty"`
+	SafeLocations         []DeviceAttributesSafeLocation `json:"safe_locations,omitempty"`
+	LastUpdateTimestamp   time.Time                      `json:"last_update_timestamp"`
+	LastUpdate            string                         `json:"last_update"`
+	Provider              string                         `json:"provider"`
+	Source                string                         `json:"source"`
+	Zone                  string                         `json:"zone"`
+	ZoneDistance          *float64                       `json:"zone_distance,omitempty"`
+	HomeDistance          *float64                       `json:"home_distance,omitempty"`
+	Stale                 bool                           `json:"stale"`
 }
 
-type DeviceAttributes struct {
-	Latitude            float64   `json:"latitude"`
-	Longitude           float64   `json:"longitude"`
-	GPSAccuracy         float64   `json:"gps_accuracy"`
-	Address             string    `json:"address"`
-	BatteryStatus       string    `json:"batteryStatus"`
-	LastUpdateTimestamp time.Time `json:"last_update_timestamp"`
-	LastUpdate          string    `json:"last_update"`
-	Provider            string    `json:"provider"`
+type DeviceAttributesLocation struct {
+	Latitude     float64 `json:"latitude"`
+	Longitude    float64 `json:"longitude"`
+	GPSAccuracy  float64 `json:"gps_accuracy"`
+	IsOld        bool    `json:"is_old"`
+	IsInaccurate bool    `json:"is_inaccurate"`
+	PositionType string  `json:"position_type"`
+	LastUpdate   string  `json:"last_update"`
+}
+
+type DeviceAttributesSafeLocation struct {
+	Name      string  `json:"name"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Address   string  `json:"address,omitempty"`
+}
+
+type DeviceEvent struct {
+	Type          string         `json:"type"`
+	DeviceID      string         `json:"device_id"`
+	PreviousZone  string         `json:"previous_zone"`
+	Zone          string         `json:"zone"`
+	PreviousSince string         `json:"previous_since"`
+	Since         string         `json:"since"`
+	Timestamp     string         `json:"timestamp"`
+	Dwell         float64        `json:"dwell"`
+	Fix           DeviceEventFix `json:"fix"`
+}
+
+type DeviceEventFix struct {
+	Latitude    float64 `json:"latitude"`
+	Longitude   float64 `json:"longitude"`
+	GPSAccuracy float64 `json:"gps_accuracy"`
+	LastUpdate  string  `json:"last_update"`
+	Source      string  `json:"source"`
 }
 
 type cacheSyncMQTTController struct {
-	config                config.Config
-	deviceUsecase         interfaces.IDeviceUsecase
-	knownLocationsUsecase interfaces.IKnownLocationsUsecase
-	logger                logging.Logger
-	mqtt                  interfaces.IMQTTClient
+	availability           map[string]string
+	availabilityMu         sync.Mutex
+	config                 config.Config
+	deviceUsecase          interfaces.IDeviceUsecase
+	hassDiscoveryPublisher interfaces.IHassDiscoveryPublisher
+	ignoredDevicesUsecase  interfaces.IIgnoredDevicesUsecase
+	knownLocationsUsecase  interfaces.IKnownLocationsUsecase
+	logger                 logging.Logger
+	mqtt                   interfaces.IMQTTClient
+	scanning               chan struct{}
+	zoneTrackerUsecase     interfaces.IZoneTrackerUsecase
 }
 
 type CacheSyncMQTTControllerParams struct {
 	fx.In
-	Config                config.Config
-	DeviceUsecase         interfaces.IDeviceUsecase
-	KnownLocationsUsecase interfaces.IKnownLocationsUsecase
-	Logger                logging.Logger
-	Mqtt                  interfaces.IMQTTClient
+	Config                 config.Config
+	DeviceUsecase          interfaces.IDeviceUsecase
+	HassDiscoveryPublisher interfaces.IHassDiscoveryPublisher
+	IgnoredDevicesUsecase  interfaces.IIgnoredDevicesUsecase
+	KnownLocationsUsecase  interfaces.IKnownLocationsUsecase
+	Logger                 logging.Logger
+	Mqtt                   interfaces.IMQTTClient
+	ZoneTrackerUsecase     interfaces.IZoneTrackerUsecase
 }
 
 func NewCacheSyncMQTTController(p CacheSyncMQTTControllerParams) interfaces.ICacheSyncMQTTController {
 	return &cacheSyncMQTTController{
-		config:                p.Config,
-		deviceUsecase:         p.DeviceUsecase,
-		knownLocationsUsecase: p.KnownLocationsUsecase,
-		logger:                p.Logger,
-		mqtt:                  p.Mqtt,
+		availability:           make(map[string]string),
+		config:                 p.Config,
+		deviceUsecase:          p.DeviceUsecase,
+		hassDiscoveryPublisher: p.HassDiscoveryPublisher,
+		ignoredDevicesUsecase:  p.IgnoredDevicesUsecase,
+		knownLocationsUsecase:  p.KnownLocationsUsecase,
+		logger:                 p.Logger,
+		mqtt:                   p.Mqtt,
+		scanning:               make(chan struct{}, 1),
+		zoneTrackerUsecase:     p.ZoneTrackerUsecase,
 	}
 }
 
-func (csmc *cacheSyncMQTTController) Process(forceSync bool) {
+// Process publishes the devices read from the location sources on
+// scan_concurrency workers and waits for them, within scan_timeout. A scan
+// waits for the previous one to finish before starting.
+func (csmc *cacheSyncMQTTController) Process(ctx context.Context, forceSync bool) (entities.ScanResult, error) {
 	const names = "__cache_sync_mqtt_controller.go__: Process"
-	if err := csmc.mqtt.Connect(); err != nil {
-		csmc.logger.Error(fmt.Sprintf("%s | %s", names, err.Error()))
-		panic(err)
+	select {
+	case csmc.scanning <- struct{}{}:
+		defer func() { <-csmc.scanning }()
+	case <-ctx.Done():
+		return entities.ScanResult{}, ctx.Err()
 	}
-	devices, err := csmc.deviceUsecase.GetDevicesCache()
-	if err != nil {
-		csmc.logger.Warn(fmt.Sprintf("%s | %s", names, err.Error()))
-		return
+	start := time.Now()
+	if csmc.config.ScanTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(csmc.config.ScanTimeout)*time.Second)
+		defer cancel()
+	}
+
+	if !csmc.mqtt.IsConnected() {
+		csmc.logger.Warn(fmt.Sprintf("%s | Broker not connected, messages are queued until it is back", names))
+	}
+	devices, err := csmc.getDevices()
+	if err != nil && len(devices) == 0 {
+		return entities.ScanResult{Duration: time.Since(start)}, err
+	}
+	// A device missing because a location source failed is not gone.
+	if err == nil {
+		csmc.removeExpiredDevices()
 	}
 	csmc.logger.Info(fmt.Sprintf("%s | Processing %d devices", names, len(devices)))
+
+	var result entities.ScanResult
+	var jobs []func() error
+	var ids []string
+	thresholds := publishThresholds(csmc.config)
 	for _, device := range devices {
-		if !forceSync && csmc.deviceUsecase.HasDeviceMustBeUpdated(device.ID, device.Name, device.LastUpdate) {
+		csmc.updateDeviceAvailability(device, forceSync)
+		now := time.Now()
+		// The zone only changes with a new fix, with new known locations on a
+		// forced scan, or once the dwell time of a pending transition has
+		// elapsed, even when the device stopped reporting.
+		zoneState, tracked := csmc.zoneTrackerUsecase.GetState(device.ID)
+		var events []entities.ZoneEvent
+		if forceSync || !tracked || zoneState.CandidateZone != "" || !zoneState.Fix.LastUpdate.Equal(device.LastUpdate) {
+			zoneState, events = csmc.zoneTrackerUsecase.Track(device, now)
+		}
+		attributes := csmc.newDeviceAttributes(device, zoneState)
+		content, marshalErr := json.Marshal(attributes.withoutThresholdFields())
+		if marshalErr != nil {
+			result.Failed++
+			csmc.logger.Error(fmt.Sprintf("%s | %s: %s", names, device.ID, marshalErr.Error()))
+			continue
+		}
+		if !forceSync && len(events) == 0 && !csmc.deviceUsecase.MustPublish(device, zoneState.Zone, content, thresholds, now) {
+			result.Skipped++
 			continue
 		}
-		go csmc.processDevice(device)
+		device := device
+		jobs = append(jobs, func() error {
+			return csmc.processDevice(device, zoneState, events, attributes, content)
+		})
+		ids = append(ids, device.ID)
+	}
+	for i, jobErr := range csmc.runJobs(ctx, jobs) {
+		if jobErr != nil {
+			result.Failed++
+			csmc.logger.Error(fmt.Sprintf("%s | %s: %s", names, ids[i], jobErr.Error()))
+			continue
+		}
+		result.Published++
 	}
-}
 
-func (csmc *cacheSyncMQTTController) processDevice(device entities.Device) {
-	const names = "__cache_sync_mqtt_controller.go__: processDevice"
-	topics := []string{csmc.config.Mqtt.Topic, csmc.config.Mqtt.HassTopic}
-	for _, topic := range topics {
-		locationName := csmc.knownLocationsUsecase.GetLocationName(entities.KnownLocation{
-			Latitude:  device.Latitude,
-			Longitude: device.Longitude,
-			Tolerance: float64(csmc.config.KnownLocationsDefaultTolerance),
-		})
-		deviceTopic := fmt.Sprintf("%s/%s/", topic, device.ID)
-		if configJSON, attributesJSON, err := createDeviceConfigAndAttributes(device, deviceTopic); err != nil {
-			csmc.logger.Error(fmt.Sprintf("%s | %s", names, err.Error()))
-		} else {
-			if err := csmc.mqtt.Publish(deviceTopic+"config", configJSON); err != nil {
-				csmc.logger.Error(fmt.Sprintf("%s | %s", names, err.Error()))
-			}
-			if err := csmc.mqtt.Publish(deviceTopic+"attributes", attributesJSON); err != nil {
-				csmc.logger.Error(fmt.Sprintf("%s | %s", names, err.Error()))
-			}
-			if err := csmc.mqtt.Publish(deviceTopic+"state", []byte(locationName)); err != nil {
-				csmc.logger.Error(fmt.Sprintf("%s | %s", names, err.Error()))
+	if csmc.config.ZoneStaleAfter > 0 {
+		staleAfter := time.Duration(csmc.config.ZoneStaleAfter) * time.Second
+		var staleJobs []func() error
+		for _, event := range csmc.zoneTrackerUsecase.Expire(time.Now(), staleAfter) {
+			event := event
+			staleJobs = append(staleJobs, func() error {
+				return errors.Join(csmc.publishStale(event.DeviceID, true), csmc.publishEvent(event))
+			})
+		}
+		for _, jobErr := range csmc.runJobs(ctx, staleJobs) {
+			if jobErr != nil {
+				csmc.logger.Error(fmt.Sprintf("%s | %s", names, jobErr.Error()))
 			}
 		}
 	}
+	if flushErr := csmc.deviceUsecase.FlushStates(); flushErr != nil {
+		csmc.logger.Warn(fmt.Sprintf("%s | %s", names, flushErr.Error()))
+	}
+	result.Duration = time.Since(start)
+	csmc.logger.Info(fmt.Sprintf("%s | Scan done in %s: %d published, %d skipped, %d failed", names, result.Duration.Round(time.Millisecond), result.Published, result.Skipped, result.Failed))
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		err = errors.Join(err, fmt.Errorf("scan interrupted: %w", ctxErr))
+	}
+	return result, err
 }
 
-func createDeviceConfigAndAttributes(device entities.Device, topic string) (configJSON []byte, attributesJSON []byte, err error) {
-	deviceHassTopic := fmt.Sprintf("%s/%s/", topic, device.ID)
-	deviceConfig := DeviceConfig{
-		UniqueID:            device.ID,
-		StateTopic:          deviceHassTopic + "state",
-		JSONAttributesTopic: deviceHassTopic + "attributes",
-		SourceType:          device.SourceType,
-		PayloadHome:         "home",
-		PayloadNotHome:      "not_home",
+// runJobs runs jobs on scan_concurrency workers and returns the error of each
+// job. The jobs not started before ctx is done fail with its error.
+func (csmc *cacheSyncMQTTController) runJobs(ctx context.Context, jobs []func() error) []error {
+	errs := make([]error, len(jobs))
+	indexes := make(chan int)
+	workers := csmc.config.ScanConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(jobs); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := ctx.Err(); err != nil {
+					errs[i] = err
+					continue
+				}
+				errs[i] = jobs[i]()
+			}
+		}()
 	}
-	deviceConfig.Device.Identifiers = device.ID
-	deviceConfig.Device.Manufacturer = "Apple"
-	deviceConfig.Device.Name = device.Name
-	deviceConfig.Device.Mdl = device.ModelName
+	for i := range jobs {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return errs
+}
 
-	deviceAttributes := DeviceAttributes{
-		Latitude:            device.Latitude,
-		Longitude:           device.Longitude,
-		GPSAccuracy:         device.GPSAccuracy,
-		Address:             device.Address,
-		BatteryStatus:       device.BatteryStatus,
-		LastUpdateTimestamp: device.LastUpdate,
-		LastUpdate:          device.LastUpdate.Format(time.RFC3339),
-		Provider:            "Apple FindMy To MQTT",
+// PublishDiscovery publishes again the discovery configs of every device, for
+// a Home Assistant that lost them.
+func (csmc *cacheSyncMQTTController) PublishDiscovery() error {
+	devices, err := csmc.getDevices()
+	errs := []error{err}
+	for _, device := range devices {
+		errs = append(errs, csmc.hassDiscoveryPublisher.PublishDevice(device, true))
+	}
+	return errors.Join(errs...)
+}
+
+// RemoveDevice removes a device from the broker and forgets its state.
+func (csmc *cacheSyncMQTTController) RemoveDevice(id string) error {
+	if err := csmc.removeDevice(id); err != nil {
+		return err
+	}
+	csmc.deviceUsecase.ForgetDevice(id)
+	return nil
+}
+
+// getDevices returns the devices read from the location sources, except the
+// ignored ones.
+func (csmc *cacheSyncMQTTController) getDevices() ([]entities.Device, error) {
+	devices, err := csmc.deviceUsecase.GetDevicesCache()
+	kept := devices[:0]
+	for _, device := range devices {
+		if !csmc.ignoredDevicesUsecase.IsIgnored(device.ID) {
+			kept = append(kept, device)
+		}
 	}
+	return kept, err
+}
 
-	configJSON, err = json.Marshal(deviceConfig)
+// processDevice publishes a device and its events, and records what was
+// published once everything went through.
+func (csmc *cacheSyncMQTTController) processDevice(device entities.Device, zoneState entities.ZoneState, events []entities.ZoneEvent, attributes DeviceAttributes, content []byte) error {
+	attributesJSON, err := json.Marshal(attributes)
 	if err != nil {
-		return nil, nil, err
+		return err
+	}
+	errs := []error{
+		csmc.hassDiscoveryPublisher.PublishDevice(device, false),
+		csmc.mqtt.Publish(deviceTopic(csmc.config, device.ID, "attributes"), attributesJSON, publishOptions(csmc.config, interfaces.MessageClassAttributes)),
+		csmc.mqtt.Publish(deviceTopic(csmc.config, device.ID, "state"), []byte(zoneState.Zone), publishOptions(csmc.config, interfaces.MessageClassState)),
+		csmc.publishStale(device.ID, zoneState.Stale),
 	}
+	for _, event := range events {
+		errs = append(errs, csmc.publishEvent(event))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	// A device that failed is published again by the next scan.
+	csmc.deviceUsecase.MarkPublished(device, zoneState.Zone, content, time.Now())
+	return nil
+}
+
+// newDeviceAttributes returns the attributes of a device, with its distance
+// from home.
+func (csmc *cacheSyncMQTTController) newDeviceAttributes(device entities.Device, zoneState entities.ZoneState) DeviceAttributes {
+	var homeDistance *float64
+	point := entities.KnownLocation{Latitude: device.Latitude, Longitude: device.Longitude}
+	if distance, ok := csmc.knownLocationsUsecase.GetLocationDistance(entities.Home, point); ok {
+		homeDistance = &distance
+	}
+	return createDeviceAttributes(device, zoneState, homeDistance)
+}
+
+// removeExpiredDevices removes from the broker the devices that have not been
+// read for cleanup_grace_period.
+func (csmc *cacheSyncMQTTController) removeExpiredDevices() {
+	const names = "__cache_sync_mqtt_controller.go__: removeExpiredDevices"
+	if csmc.config.CleanupGracePeriod <= 0 {
+		return
+	}
+	gracePeriod := time.Duration(csmc.config.CleanupGracePeriod) * time.Second
+	for _, device := range csmc.deviceUsecase.GetExpired(time.Now(), gracePeriod) {
+		csmc.logger.Info(fmt.Sprintf("%s | Removing %s (%s), not seen since %s", names, device.Name, device.ID, device.LastSeen.Format(time.RFC3339)))
+		if err := csmc.RemoveDevice(device.ID); err != nil {
+			csmc.logger.Error(fmt.Sprintf("%s | %s", names, err.Error()))
+		}
+	}
+}
+
+// removeDevice removes the discovery configs and the retained messages of a
+// device.
+func (csmc *cacheSyncMQTTController) removeDevice(id string) error {
+	errs := []error{csmc.hassDiscoveryPublisher.RemoveDevice(id)}
+	options := publishOptions(csmc.config, interfaces.MessageClassState)
+	options.Retain = true
+	for _, suffix := range []string{"attributes", "availability", "stale", "state"} {
+		errs = append(errs, csmc.mqtt.Publish(deviceTopic(csmc.config, id, suffix), []byte{}, options))
+	}
+	csmc.availabilityMu.Lock()
+	delete(csmc.availability, id)
+	csmc.availabilityMu.Unlock()
+	return errors.Join(errs...)
+}
+
+// updateDeviceAvailability publishes the availability of a device when it
+// changes: a device is offline when its fix is older than
+// device_availability_timeout.
+func (csmc *cacheSyncMQTTController) updateDeviceAvailability(device entities.Device, force bool) {
+	const names = "__cache_sync_mqtt_controller.go__: updateDeviceAvailability"
+	if csmc.config.DeviceAvailabilityTimeout <= 0 {
+		return
+	}
+	availability := "online"
+	if time.Since(device.LastUpdate) > time.Duration(csmc.config.DeviceAvailabilityTimeout)*time.Second {
+		availability = "offline"
+	}
+	csmc.availabilityMu.Lock()
+	changed := csmc.availability[device.ID] != availability
+	csmc.availability[device.ID] = availability
+	csmc.availabilityMu.Unlock()
+	if !changed && !force {
+		return
+	}
+	if err := csmc.mqtt.Publish(deviceTopic(csmc.config, device.ID, "availability"), []byte(availability), publishOptions(csmc.config, interfaces.MessageClassState)); err != nil {
+		csmc.logger.Error(fmt.Sprintf("%s | %s", names, err.Error()))
+	}
+}
 
-	attributesJSON, err = json.Marshal(deviceAttributes)
+// publishStale publishes whether the location of a device is stale to
+// <topic>/<id>/stale.
+func (csmc *cacheSyncMQTTController) publishStale(id string, stale bool) error {
+	payload := "OFF"
+	if stale {
+		payload = "ON"
+	}
+	return csmc.mqtt.Publish(deviceTopic(csmc.config, id, "stale"), []byte(payload), publishOptions(csmc.config, interfaces.MessageClassState))
+}
+
+// publishEvent publishes a zone event to <topic>/<id>/event.
+func (csmc *cacheSyncMQTTController) publishEvent(event entities.ZoneEvent) error {
+	const names = "__cache_sync_mqtt_controller.go__: publishEvent"
+	eventJSON, err := createDeviceEvent(event)
 	if err != nil {
-		return nil, nil, err
+		return err
+	}
+	csmc.logger.Info(fmt.Sprintf("%s | %s %s %s -> %s", names, event.DeviceID, event.Type, event.PreviousZone, event.Zone))
+	return csmc.mqtt.Publish(deviceTopic(csmc.config, event.DeviceID, "event"), eventJSON, publishOptions(csmc.config, interfaces.MessageClassEvent))
+}
+
+func publishThresholds(cfg config.Config) entities.PublishThresholds {
+	return entities.PublishThresholds{
+		BatteryChange: float64(cfg.PublishBatteryChange),
+		Heartbeat:     time.Duration(cfg.PublishHeartbeat) * time.Second,
+		MinDistance:   float64(cfg.PublishMinDistance),
+	}
+}
+
+// deviceTopic returns the topic <topic>/<id>/<suffix> of a device.
+func deviceTopic(cfg config.Config, id, suffix string) string {
+	return fmt.Sprintf("%s/%s/%s", cfg.Mqtt.Topic, id, suffix)
+}
+
+// publishOptions returns the QoS and retain flag configured for a class of
+// messages.
+func publishOptions(cfg config.Config, class interfaces.MessageClass) interfaces.PublishOptions {
+	var options config.MqttPublishOptions
+	switch class {
+	case interfaces.MessageClassAttributes:
+		options = cfg.Mqtt.Publish.Attributes
+	case interfaces.MessageClassDiscovery:
+		options = cfg.Mqtt.Publish.Discovery
+	case interfaces.MessageClassEvent:
+		options = cfg.Mqtt.Publish.Events
+	case interfaces.MessageClassState:
+		options = cfg.Mqtt.Publish.State
+	}
+	return interfaces.PublishOptions{
+		Class:  class,
+		QoS:    byte(options.QoS),
+		Retain: options.Retain,
 	}
+}
+
+func createDeviceEvent(event entities.ZoneEvent) ([]byte, error) {
+	return json.Marshal(DeviceEvent{
+		Type:          string(event.Type),
+		DeviceID:      event.DeviceID,
+		PreviousZone:  event.PreviousZone,
+		Zone:          event.Zone,
+		PreviousSince: event.PreviousSince.Format(time.RFC3339),
+		Since:         event.Since.Format(time.RFC3339),
+		Timestamp:     event.Timestamp.Format(time.RFC3339),
+		Dwell:         event.Dwell.Seconds(),
+		Fix: DeviceEventFix{
+			Latitude:    event.Fix.Latitude,
+			Longitude:   event.Fix.Longitude,
+			GPSAccuracy: event.Fix.GPSAccuracy,
+			LastUpdate:  event.Fix.LastUpdate.Format(time.RFC3339),
+			Source:      event.Fix.Source,
+		},
+	})
+}
+
+func createDeviceAttributes(device entities.Device, zoneState entities.ZoneState, homeDistance *float64) DeviceAttributes {
+	deviceAttributes := DeviceAttributes{
+		Latitude:              device.Latitude,
+		Longitude:             device.Longitude,
+		Altitude:              device.Altitude,
+		FloorLevel:            device.FloorLevel,
+		GPSAccuracy:           device.GPSAccuracy,
+		IsOld:                 device.IsOld,
+		IsInaccurate:          device.IsInaccurate,
+		Address:               device.Address,
+		StreetAddress:         device.AddressDetails.StreetAddress,
+		PostalCode:            device.AddressDetails.PostalCode,
+		Locality:              device.AddressDetails.Locality,
+		SubAdministrativeArea: device.AddressDetails.SubAdministrativeArea,
+		AdministrativeArea:    device.AddressDetails.AdministrativeArea,
+		StateCode:             device.AddressDetails.StateCode,
+		Country:               device.AddressDetails.Country,
+		CountryCode:           device.AddressDetails.CountryCode,
+		BatteryStatus:         device.BatteryStatus,
+		BatteryLevel:          device.BatteryLevel,
+		LowPowerMode:          device.LowPowerMode,
+		ProductType:           device.ProductType,
+		Role:                  device.Role.Name,
+		RoleEmoji:             device.Role.Emoji,
+		PartInfo:              device.PartInfo,
+		LastUpdateTimestamp:   device.LastUpdate,
+		LastUpdate:            device.LastUpdate.Format(time.RFC3339),
+		Provider:              "Apple FindMy To MQTT",
+		Source:                device.Source,
+		Zone:                  zoneState.Zone,
+		ZoneDistance:          zoneState.Distance,
+		HomeDistance:          homeDistance,
+		Stale:                 zoneState.Stale,
+	}
+	if device.CrowdSourcedLocation != nil {
+		deviceAttributes.CrowdSourcedLocation = &DeviceAttributesLocation{
+			Latitude:     device.CrowdSourcedLocation.Latitude,
+			Longitude:    device.CrowdSourcedLocation.Longitude,
+			GPSAccuracy:  device.CrowdSourcedLocation.GPSAccuracy,
+			IsOld:        device.CrowdSourcedLocation.IsOld,
+			IsInaccurate: device.CrowdSourcedLocation.IsInaccurate,
+			PositionType: device.CrowdSourcedLocation.PositionType,
+			LastUpdate:   device.CrowdSourcedLocation.LastUpdate.Format(time.RFC3339),
+		}
+	}
+	for _, safeLocation := range device.SafeLocations {
+		deviceAttributes.SafeLocations = append(deviceAttributes.SafeLocations, DeviceAttributesSafeLocation{
+			Name:      safeLocation.Name,
+			Latitude:  safeLocation.Latitude,
+			Longitude: safeLocation.Longitude,
+			Address:   safeLocation.Address,
+		})
+	}
+
+	return deviceAttributes
+}
 
-	return configJSON, attributesJSON, nil
+// withoutThresholdFields returns the attributes without the position, the
+// battery level and the fields that change with them, which are compared
+// with the publish thresholds instead.
+func (da DeviceAttributes) withoutThresholdFields() DeviceAttributes {
+	da.Latitude = 0
+	da.Longitude = 0
+	da.Altitude = 0
+	da.GPSAccuracy = 0
+	da.BatteryLevel = nil
+	da.CrowdSourcedLocation = nil
+	da.LastUpdateTimestamp = time.Time{}
+	da.LastUpdate = ""
+	da.ZoneDistance = nil
+	da.HomeDistance = nil
+	return da
 }
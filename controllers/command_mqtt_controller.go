@@ -0,0 +1,195 @@
+package controllers
+
+import (
+	"apple-findmy-to-mqtt/core/interfaces"
+	"apple-findmy-to-mqtt/infrastructure/config"
+	"apple-findmy-to-mqtt/infrastructure/logging"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+const (
+	CommandIgnoreDevice       = "ignore_device"
+	CommandRefresh            = "refresh"
+	CommandReloadZones        = "reload_zones"
+	CommandRepublishDiscovery = "republish_discovery"
+	CommandSetLogLevel        = "set_log_level"
+	CommandUnignoreDevice     = "unignore_device"
+)
+
+const (
+	commandStatusError = "error"
+	commandStatusOK    = "ok"
+	hassStatusOnline   = "online"
+)
+
+// BridgeCommand is the optional JSON payload of a command. The ID is sent
+// back in the response to correlate them.
+type BridgeCommand struct {
+	ID       string `json:"id"`
+	DeviceID string `json:"device_id"`
+	Level    string `json:"level"`
+}
+
+type BridgeResponse struct {
+	ID      string `json:"id,omitempty"`
+	Command string `json:"command"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+}
+
+type commandMQTTController struct {
+	cacheSyncMQTTController interfaces.ICacheSyncMQTTController
+	config                  config.Config
+	ctx                     context.Context
+	ignoredDevicesUsecase   interfaces.IIgnoredDevicesUsecase
+	knownLocationsUsecase   interfaces.IKnownLocationsUsecase
+	logger                  logging.Logger
+	mqtt                    interfaces.IMQTTClient
+}
+
+type CommandMQTTControllerParams struct {
+	fx.In
+	CacheSyncMQTTController interfaces.ICacheSyncMQTTController
+	Config                  config.Config
+	IgnoredDevicesUsecase   interfaces.IIgnoredDevicesUsecase
+	KnownLocationsUsecase   interfaces.IKnownLocationsUsecase
+	Lifecycle               fx.Lifecycle
+	Logger                  logging.Logger
+	Mqtt                    interfaces.IMQTTClient
+}
+
+// NewCommandMQTTController returns a controller whose scans are interrupted
+// when the application stops.
+func NewCommandMQTTController(p CommandMQTTControllerParams) interfaces.ICommandMQTTController {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.Lifecycle.Append(fx.Hook{
+		OnStop: func(context.Context) error {
+			cancel()
+			return nil
+		},
+	})
+	return &commandMQTTController{
+		cacheSyncMQTTController: p.CacheSyncMQTTController,
+		config:                  p.Config,
+		ctx:                     ctx,
+		ignoredDevicesUsecase:   p.IgnoredDevicesUsecase,
+		knownLocationsUsecase:   p.KnownLocationsUsecase,
+		logger:                  p.Logger,
+		mqtt:                    p.Mqtt,
+	}
+}
+
+// Subscribe listens to the commands sent to <topic>/bridge/command/<command>
+// and to the birth message of Home Assistant on <hass_topic>/status.
+// Messages are handled in their own goroutine, as the MQTT client does not
+// deliver the next ones until the handler returns. Retained commands are
+// dropped, as they would run again on every connection.
+func (cmc *commandMQTTController) Subscribe() error {
+	const names = "__command_mqtt_controller.go__: Subscribe"
+	if err := cmc.mqtt.Subscribe(cmc.commandTopic()+"/#", func(message interfaces.IMessage) {
+		if message.Retained() {
+			cmc.logger.Warn(fmt.Sprintf("%s | Ignoring retained command on %s, publish commands without the retain flag", names, message.Topic()))
+			return
+		}
+		go cmc.handleCommand(message.Topic(), message.Payload())
+	}); err != nil {
+		return err
+	}
+	return cmc.mqtt.Subscribe(cmc.config.Mqtt.HassTopic+"/status", func(message interfaces.IMessage) {
+		if string(message.Payload()) == hassStatusOnline {
+			go cmc.republishDiscovery()
+		}
+	})
+}
+
+func (cmc *commandMQTTController) handleCommand(topic string, payload []byte) {
+	const names = "__command_mqtt_controller.go__: handleCommand"
+	name := strings.TrimPrefix(strings.TrimPrefix(topic, cmc.commandTopic()), "/")
+	var command BridgeCommand
+	var err error
+	if len(payload) > 0 {
+		if err = json.Unmarshal(payload, &command); err != nil {
+			err = fmt.Errorf("invalid payload: %w", err)
+		}
+	}
+	if err == nil {
+		cmc.logger.Info(fmt.Sprintf("%s | Received %s command %s", names, name, command.ID))
+		err = cmc.runCommand(name, command)
+	}
+
+	response := BridgeResponse{
+		ID:      command.ID,
+		Command: name,
+		Status:  commandStatusOK,
+	}
+	if err != nil {
+		cmc.logger.Warn(fmt.Sprintf("%s | %s command failed: %s", names, name, err.Error()))
+		response.Status = commandStatusError
+		response.Error = err.Error()
+	}
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		cmc.logger.Error(fmt.Sprintf("%s | %s", names, err.Error()))
+		return
+	}
+	options := publishOptions(cmc.config, interfaces.MessageClassEvent)
+	options.Retain = false
+	if err := cmc.mqtt.Publish(cmc.config.Mqtt.Topic+"/bridge/response", responseJSON, options); err != nil {
+		cmc.logger.Error(fmt.Sprintf("%s | %s", names, err.Error()))
+	}
+}
+
+func (cmc *commandMQTTController) runCommand(name string, command BridgeCommand) error {
+	switch name {
+	case CommandIgnoreDevice:
+		if command.DeviceID == "" {
+			return errors.New("device_id is required")
+		}
+		if err := cmc.ignoredDevicesUsecase.Ignore(command.DeviceID, time.Now()); err != nil {
+			return err
+		}
+		return cmc.cacheSyncMQTTController.RemoveDevice(command.DeviceID)
+	case CommandRefresh:
+		_, err := cmc.cacheSyncMQTTController.Process(cmc.ctx, true)
+		return err
+	case CommandReloadZones:
+		return cmc.knownLocationsUsecase.Reload()
+	case CommandRepublishDiscovery:
+		return cmc.cacheSyncMQTTController.PublishDiscovery()
+	case CommandSetLogLevel:
+		return logging.SetLevel(command.Level)
+	case CommandUnignoreDevice:
+		if command.DeviceID == "" {
+			return errors.New("device_id is required")
+		}
+		ignored, err := cmc.ignoredDevicesUsecase.Unignore(command.DeviceID)
+		if err != nil {
+			return err
+		}
+		if !ignored {
+			return fmt.Errorf("device %s is not ignored", command.DeviceID)
+		}
+		_, err = cmc.cacheSyncMQTTController.Process(cmc.ctx, true)
+		return err
+	}
+	return fmt.Errorf("unknown command %q", name)
+}
+
+func (cmc *commandMQTTController) republishDiscovery() {
+	const names = "__command_mqtt_controller.go__: republishDiscovery"
+	cmc.logger.Info(fmt.Sprintf("%s | Home Assistant is online, republishing discovery", names))
+	if err := cmc.cacheSyncMQTTController.PublishDiscovery(); err != nil {
+		cmc.logger.Error(fmt.Sprintf("%s | %s", names, err.Error()))
+	}
+}
+
+func (cmc *commandMQTTController) commandTopic() string {
+	return cmc.config.Mqtt.Topic + "/bridge/command"
+}
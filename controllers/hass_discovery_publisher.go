@@ -0,0 +1,359 @@
+package controllers
+
+import (
+	"apple-findmy-to-mqtt/core/entities"
+	"apple-findmy-to-mqtt/core/interfaces"
+	"apple-findmy-to-mqtt/infrastructure/config"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.uber.org/fx"
+)
+
+const (
+	hassOriginName       = "Apple FindMy To MQTT"
+	hassOriginSupportURL = "https://github.com/AC-CodeProd/apple-findmy-to-mqtt"
+	hassPayloadReset     = "reset"
+)
+
+type HassDeviceTrackerConfig struct {
+	Name                *string            `json:"name"`
+	ObjectID            string             `json:"object_id"`
+	UniqueID            string             `json:"unique_id"`
+	Device              HassDevice         `json:"device"`
+	Origin              HassOrigin         `json:"origin"`
+	Availability        []HassAvailability `json:"availability"`
+	AvailabilityMode    string             `json:"availability_mode,omitempty"`
+	StateTopic          string             `json:"state_topic"`
+	JSONAttributesTopic string             `json:"json_attributes_topic"`
+	PayloadHome         string             `json:"payload_home"`
+	PayloadNotHome      string             `json:"payload_not_home"`
+	PayloadReset        string             `json:"payload_reset"`
+	SourceType          string             `json:"source_type"`
+}
+
+// HassEntityConfig is the discovery config of the sensor and binary sensor
+// entities attached to a device.
+type HassEntityConfig struct {
+	Name              string             `json:"name"`
+	ObjectID          string             `json:"object_id"`
+	UniqueID          string             `json:"unique_id"`
+	Device            HassDevice         `json:"device"`
+	Origin            HassOrigin         `json:"origin"`
+	Availability      []HassAvailability `json:"availability"`
+	AvailabilityMode  string             `json:"availability_mode,omitempty"`
+	StateTopic        string             `json:"state_topic"`
+	ValueTemplate     string             `json:"value_template,omitempty"`
+	DeviceClass       string             `json:"device_class,omitempty"`
+	StateClass        string             `json:"state_class,omitempty"`
+	UnitOfMeasurement string             `json:"unit_of_measurement,omitempty"`
+	EntityCategory    string             `json:"entity_category,omitempty"`
+	Icon              string             `json:"icon,omitempty"`
+	PayloadOn         string             `json:"payload_on,omitempty"`
+	PayloadOff        string             `json:"payload_off,omitempty"`
+}
+
+type HassDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model,omitempty"`
+	Name         string   `json:"name"`
+}
+
+type HassOrigin struct {
+	Name       string `json:"name"`
+	SupportURL string `json:"support_url"`
+}
+
+type HassAvailability struct {
+	Topic string `json:"topic"`
+}
+
+// hassEntity describes an entity announced for each device, reading its
+// state from one of the topics of the device.
+type hassEntity struct {
+	component      string
+	deviceClass    string
+	enabled        func(config.HassEntities) bool
+	entityCategory string
+	icon           string
+	key            string
+	name           string
+	payloadOff     string
+	payloadOn      string
+	stateClass     string
+	topic          string
+	unit           string
+	valueTemplate  string
+}
+
+var hassEntities = []hassEntity{
+	{
+		component:     "sensor",
+		deviceClass:   "battery",
+		enabled:       func(he config.HassEntities) bool { return he.BatteryLevel },
+		key:           "battery_level",
+		name:          "Battery",
+		stateClass:    "measurement",
+		topic:         "attributes",
+		unit:          "%",
+		valueTemplate: "{{ value_json.battery_level | default(None) }}",
+	},
+	{
+		component:     "sensor",
+		enabled:       func(he config.HassEntities) bool { return he.BatteryStatus },
+		icon:          "mdi:battery-heart-variant",
+		key:           "battery_status",
+		name:          "Battery status",
+		topic:         "attributes",
+		valueTemplate: "{{ value_json.batteryStatus }}",
+	},
+	{
+		component:      "sensor",
+		deviceClass:    "distance",
+		enabled:        func(he config.HassEntities) bool { return he.GPSAccuracy },
+		entityCategory: "diagnostic",
+		key:            "gps_accuracy",
+		name:           "GPS accuracy",
+		stateClass:     "measurement",
+		topic:          "attributes",
+		unit:           "m",
+		valueTemplate:  "{{ value_json.gps_accuracy }}",
+	},
+	{
+		component:     "sensor",
+		enabled:       func(he config.HassEntities) bool { return he.Address },
+		icon:          "mdi:map-marker-radius",
+		key:           "address",
+		name:          "Address",
+		topic:         "attributes",
+		valueTemplate: "{{ value_json.address[:255] }}",
+	},
+	{
+		component:     "sensor",
+		deviceClass:   "timestamp",
+		enabled:       func(he config.HassEntities) bool { return he.LastUpdate },
+		key:           "last_update",
+		name:          "Last update",
+		topic:         "attributes",
+		valueTemplate: "{{ value_json.last_update }}",
+	},
+	{
+		component:     "sensor",
+		deviceClass:   "distance",
+		enabled:       func(he config.HassEntities) bool { return he.HomeDistance },
+		key:           "home_distance",
+		name:          "Distance from home",
+		stateClass:    "measurement",
+		topic:         "attributes",
+		unit:          "m",
+		valueTemplate: "{{ value_json.home_distance | default(None) }}",
+	},
+	{
+		component: "sensor",
+		enabled:   func(he config.HassEntities) bool { return he.Zone },
+		icon:      "mdi:map-marker",
+		key:       "zone",
+		name:      "Zone",
+		topic:     "state",
+	},
+	{
+		component:      "binary_sensor",
+		deviceClass:    "problem",
+		enabled:        func(he config.HassEntities) bool { return he.Stale },
+		entityCategory: "diagnostic",
+		key:            "stale",
+		name:           "Stale location",
+		payloadOff:     "OFF",
+		payloadOn:      "ON",
+		topic:          "stale",
+	},
+}
+
+type hassDiscoveryPublisher struct {
+	announced map[string]struct{}
+	config    config.Config
+	hashes    map[string][sha256.Size]byte
+	mqtt      interfaces.IMQTTClient
+	mu        sync.Mutex
+}
+
+type HassDiscoveryPublisherParams struct {
+	fx.In
+	Config config.Config
+	Mqtt   interfaces.IMQTTClient
+}
+
+func NewHassDiscoveryPublisher(p HassDiscoveryPublisherParams) interfaces.IHassDiscoveryPublisher {
+	return &hassDiscoveryPublisher{
+		announced: make(map[string]struct{}),
+		config:    p.Config,
+		hashes:    make(map[string][sha256.Size]byte),
+		mqtt:      p.Mqtt,
+	}
+}
+
+// PublishDevice announces the device tracker of a device on
+// <hass_topic>/device_tracker/<id>/config, and its enabled entities on
+// <hass_topic>/<component>/<id>_<key>/config. A config already announced is
+// only sent again when it changed, e.g. on rename, or when force is set. The
+// entities disabled in the configuration are removed from Home Assistant the
+// first time the device is announced.
+func (hdp *hassDiscoveryPublisher) PublishDevice(device entities.Device, force bool) error {
+	options := publishOptions(hdp.config, interfaces.MessageClassDiscovery)
+	configJSON, err := createDeviceTrackerConfig(hdp.config, device)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	if err := hdp.publishConfig(hassDiscoveryTopic(hdp.config, "device_tracker", device.ID), configJSON, options, force); err != nil {
+		errs = append(errs, err)
+	}
+
+	hdp.mu.Lock()
+	_, announced := hdp.announced[device.ID]
+	hdp.announced[device.ID] = struct{}{}
+	hdp.mu.Unlock()
+	for _, entity := range hassEntities {
+		topic := hassDiscoveryTopic(hdp.config, entity.component, device.ID+"_"+entity.key)
+		if !entity.enabled(hdp.config.HassEntities) {
+			if !announced {
+				// An empty retained config removes the entity.
+				removeOptions := options
+				removeOptions.Retain = true
+				if err := hdp.mqtt.Publish(topic, []byte{}, removeOptions); err != nil {
+					errs = append(errs, err)
+				}
+			}
+			continue
+		}
+		entityJSON, err := createEntityConfig(hdp.config, device, entity)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := hdp.publishConfig(topic, entityJSON, options, force); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// publishConfig publishes a discovery config unless the same config was
+// already published to topic.
+func (hdp *hassDiscoveryPublisher) publishConfig(topic string, payload []byte, options interfaces.PublishOptions, force bool) error {
+	hash := sha256.Sum256(payload)
+	hdp.mu.Lock()
+	published, exists := hdp.hashes[topic]
+	hdp.mu.Unlock()
+	if exists && published == hash && !force {
+		return nil
+	}
+	if err := hdp.mqtt.Publish(topic, payload, options); err != nil {
+		return err
+	}
+	hdp.mu.Lock()
+	hdp.hashes[topic] = hash
+	hdp.mu.Unlock()
+	return nil
+}
+
+// RemoveDevice removes the device tracker and the entities of a device from
+// Home Assistant.
+func (hdp *hassDiscoveryPublisher) RemoveDevice(id string) error {
+	options := publishOptions(hdp.config, interfaces.MessageClassDiscovery)
+	options.Retain = true
+	topics := []string{hassDiscoveryTopic(hdp.config, "device_tracker", id)}
+	for _, entity := range hassEntities {
+		topics = append(topics, hassDiscoveryTopic(hdp.config, entity.component, id+"_"+entity.key))
+	}
+	var errs []error
+	for _, topic := range topics {
+		if err := hdp.mqtt.Publish(topic, []byte{}, options); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	hdp.mu.Lock()
+	delete(hdp.announced, id)
+	for _, topic := range topics {
+		delete(hdp.hashes, topic)
+	}
+	hdp.mu.Unlock()
+	return errors.Join(errs...)
+}
+
+func hassDiscoveryTopic(cfg config.Config, component, objectID string) string {
+	return fmt.Sprintf("%s/%s/%s/config", cfg.Mqtt.HassTopic, component, objectID)
+}
+
+// hassAvailability returns the availability topics of a device. The device
+// is only available while the bridge is.
+func hassAvailability(cfg config.Config, device entities.Device) ([]HassAvailability, string) {
+	availability := []HassAvailability{{Topic: cfg.Mqtt.BridgeAvailabilityTopic()}}
+	if cfg.DeviceAvailabilityTimeout <= 0 {
+		return availability, ""
+	}
+	availability = append(availability, HassAvailability{Topic: deviceTopic(cfg, device.ID, "availability")})
+	return availability, "all"
+}
+
+func hassDevice(device entities.Device) HassDevice {
+	return HassDevice{
+		Identifiers:  []string{device.ID},
+		Manufacturer: "Apple",
+		Model:        device.ModelName,
+		Name:         device.Name,
+	}
+}
+
+func createDeviceTrackerConfig(cfg config.Config, device entities.Device) ([]byte, error) {
+	availability, availabilityMode := hassAvailability(cfg, device)
+	return json.Marshal(HassDeviceTrackerConfig{
+		// A null name makes Home Assistant name the entity after the device.
+		Name:     nil,
+		ObjectID: device.ObjectID,
+		UniqueID: device.ID,
+		Device:   hassDevice(device),
+		Origin: HassOrigin{
+			Name:       hassOriginName,
+			SupportURL: hassOriginSupportURL,
+		},
+		Availability:        availability,
+		AvailabilityMode:    availabilityMode,
+		StateTopic:          deviceTopic(cfg, device.ID, "state"),
+		JSONAttributesTopic: deviceTopic(cfg, device.ID, "attributes"),
+		PayloadHome:         "home",
+		PayloadNotHome:      entities.NotHome,
+		PayloadReset:        hassPayloadReset,
+		SourceType:          device.SourceType,
+	})
+}
+
+func createEntityConfig(cfg config.Config, device entities.Device, entity hassEntity) ([]byte, error) {
+	availability, availabilityMode := hassAvailability(cfg, device)
+	return json.Marshal(HassEntityConfig{
+		Name:     entity.name,
+		ObjectID: device.ObjectID + "_" + entity.key,
+		UniqueID: device.ID + "_" + entity.key,
+		Device:   hassDevice(device),
+		Origin: HassOrigin{
+			Name:       hassOriginName,
+			SupportURL: hassOriginSupportURL,
+		},
+		Availability:      availability,
+		AvailabilityMode:  availabilityMode,
+		StateTopic:        deviceTopic(cfg, device.ID, entity.topic),
+		ValueTemplate:     entity.valueTemplate,
+		DeviceClass:       entity.deviceClass,
+		StateClass:        entity.stateClass,
+		UnitOfMeasurement: entity.unit,
+		EntityCategory:    entity.entityCategory,
+		Icon:              entity.icon,
+		PayloadOn:         entity.payloadOn,
+		PayloadOff:        entity.payloadOff,
+	})
+}
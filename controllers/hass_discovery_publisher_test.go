@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"apple-findmy-to-mqtt/core/entities"
+	"apple-findmy-to-mqtt/infrastructure/config"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of testdata")
+
+// discoveryTestCases covers the availability variants of the discovery
+// configs: the default bridge topic, a custom availability topic and the
+// device availability topic.
+var discoveryTestCases = []struct {
+	name string
+	cfg  func(cfg *config.Config)
+}{
+	{name: "default", cfg: func(cfg *config.Config) {}},
+	{name: "availability_topic", cfg: func(cfg *config.Config) { cfg.Mqtt.AvailabilityTopic = "home/findmy/status" }},
+	{name: "device_availability", cfg: func(cfg *config.Config) { cfg.DeviceAvailabilityTimeout = 3600 }},
+}
+
+func testDiscoveryConfig() config.Config {
+	return config.Config{
+		Mqtt: config.Mqtt{
+			HassTopic: "homeassistant",
+			Topic:     "apple_findmy",
+		},
+	}
+}
+
+func testDiscoveryDevice() entities.Device {
+	return *entities.NewDevice("", "", 0, "A1B2C3D4-0000-4000-8000-000000000001", time.Time{}, 0, 0, "AirTag", "Test AirTag", "gps")
+}
+
+// assertGolden compares payload, indented, to testdata/name.golden.
+func assertGolden(t *testing.T, name string, payload []byte) {
+	t.Helper()
+	var got bytes.Buffer
+	if err := json.Indent(&got, payload, "", "  "); err != nil {
+		t.Fatal(err)
+	}
+	got.WriteByte('\n')
+
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.WriteFile(path, got.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Errorf("%s does not match:\ngot:\n%s\nwant:\n%s", path, got.String(), want)
+	}
+}
+
+func TestCreateDeviceTrackerConfig(t *testing.T) {
+	for _, tc := range discoveryTestCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := testDiscoveryConfig()
+			tc.cfg(&cfg)
+			payload, err := createDeviceTrackerConfig(cfg, testDiscoveryDevice())
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertGolden(t, "device_tracker_"+tc.name, payload)
+		})
+	}
+}
+
+func TestCreateEntityConfig(t *testing.T) {
+	for _, tc := range discoveryTestCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := testDiscoveryConfig()
+			tc.cfg(&cfg)
+			// Every entity goes in a single golden file per case.
+			var configs []json.RawMessage
+			for _, entity := range hassEntities {
+				payload, err := createEntityConfig(cfg, testDiscoveryDevice(), entity)
+				if err != nil {
+					t.Fatal(err)
+				}
+				configs = append(configs, payload)
+			}
+			payload, err := json.Marshal(configs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertGolden(t, "entities_"+tc.name, payload)
+		})
+	}
+}
@@ -6,4 +6,7 @@ import (
 
 var Module = fx.Options(
 	fx.Provide(NewCacheSyncMQTTController),
+	fx.Provide(NewCommandMQTTController),
+	fx.Provide(NewHassDiscoveryPublisher),
+	fx.Provide(NewPurgeMQTTController),
 )
@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"apple-findmy-to-mqtt/core/interfaces"
+	"apple-findmy-to-mqtt/infrastructure/config"
+	"apple-findmy-to-mqtt/infrastructure/logging"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+// purgeCollectDelay is how long retained messages are collected after
+// subscribing. Brokers send them right after the subscription.
+const purgeCollectDelay = 3 * time.Second
+
+type purgeMQTTController struct {
+	config           config.Config
+	deviceStateStore interfaces.IDeviceStateStore
+	logger           logging.Logger
+	mqtt             interfaces.IMQTTClient
+}
+
+type PurgeMQTTControllerParams struct {
+	fx.In
+	Config           config.Config
+	DeviceStateStore interfaces.IDeviceStateStore
+	Logger           logging.Logger
+	Mqtt             interfaces.IMQTTClient
+}
+
+func NewPurgeMQTTController(p PurgeMQTTControllerParams) interfaces.IPurgeMQTTController {
+	return &purgeMQTTController{
+		config:           p.Config,
+		deviceStateStore: p.DeviceStateStore,
+		logger:           p.Logger,
+		mqtt:             p.Mqtt,
+	}
+}
+
+// Purge removes every retained message under <topic> and every discovery
+// config published by the bridge under <hass_topic>, drops the offline queue
+// and forgets the state of every device. It returns the number of topics
+// cleared.
+func (pmc *purgeMQTTController) Purge(ctx context.Context) (int, error) {
+	const names = "__purge_mqtt_controller.go__: Purge"
+	if !pmc.mqtt.IsConnected() {
+		return 0, errors.New("broker not connected")
+	}
+
+	var mu sync.Mutex
+	topics := make(map[string]struct{})
+	collect := func(topic string, payload []byte) {
+		if len(payload) == 0 {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if topics != nil {
+			topics[topic] = struct{}{}
+		}
+	}
+	if err := pmc.mqtt.Subscribe(pmc.config.Mqtt.Topic+"/#", func(message interfaces.IMessage) {
+		collect(message.Topic(), message.Payload())
+	}); err != nil {
+		return 0, err
+	}
+	if err := pmc.mqtt.Subscribe(pmc.config.Mqtt.HassTopic+"/+/+/config", func(message interfaces.IMessage) {
+		var discovery struct {
+			Origin HassOrigin `json:"origin"`
+		}
+		if json.Unmarshal(message.Payload(), &discovery) == nil && discovery.Origin.Name == hassOriginName {
+			collect(message.Topic(), message.Payload())
+		}
+	}); err != nil {
+		return 0, err
+	}
+	select {
+	case <-time.After(purgeCollectDelay):
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+
+	mu.Lock()
+	purged := make([]string, 0, len(topics))
+	for topic := range topics {
+		purged = append(purged, topic)
+	}
+	// Ignore the removals echoed back by the broker.
+	topics = nil
+	mu.Unlock()
+	sort.Strings(purged)
+
+	options := interfaces.PublishOptions{
+		Class:  interfaces.MessageClassState,
+		QoS:    1,
+		Retain: true,
+	}
+	var errs []error
+	for _, topic := range purged {
+		pmc.logger.Info(fmt.Sprintf("%s | Clearing %s", names, topic))
+		if err := pmc.mqtt.Publish(topic, []byte{}, options); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := pmc.mqtt.ClearQueue(); err != nil {
+		errs = append(errs, err)
+	}
+	// Without their state, the devices are published again as new devices
+	// on the next start instead of waiting for the heartbeat.
+	pmc.deviceStateStore.DeleteAll()
+	if err := pmc.deviceStateStore.Flush(); err != nil {
+		errs = append(errs, err)
+	}
+	return len(purged), errors.Join(errs...)
+}
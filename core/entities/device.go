@@ -12,29 +12,91 @@ import (
 )
 
 type Device struct {
-	ID            string
-	Name          string
-	ModelName     string
-	BatteryStatus string
-	SourceType    string
-	Latitude      float64
-	Longitude     float64
-	Address       string
-	GPSAccuracy   float64
-	LastUpdate    time.Time
+	ID                   string
+	ObjectID             string
+	Name                 string
+	ModelName            string
+	ProductType          string
+	Role                 DeviceRole
+	BatteryStatus        string
+	BatteryLevel         *float64
+	LowPowerMode         bool
+	SourceType           string
+	Latitude             float64
+	Longitude            float64
+	Altitude             float64
+	FloorLevel           int
+	IsOld                bool
+	IsInaccurate         bool
+	Address              string
+	AddressDetails       DeviceAddress
+	GPSAccuracy          float64
+	LastUpdate           time.Time
+	Source               string
+	CrowdSourcedLocation *DeviceLocation
+	PartInfo             map[string]any
+	SafeLocations        []DeviceSafeLocation
 }
 
-func NewDevice(address, batteryStatus string, gpsAccuracy float64, lastUpdate time.Time, latitude, longitude float64, modelName, name, sourceType string) *Device {
+type DeviceAddress struct {
+	AdministrativeArea    string
+	Country               string
+	CountryCode           string
+	FormattedAddressLines []string
+	Locality              string
+	PostalCode            string
+	StateCode             string
+	StreetAddress         string
+	StreetName            string
+	SubAdministrativeArea string
+}
+
+type DeviceLocation struct {
+	Altitude     float64
+	FloorLevel   int
+	GPSAccuracy  float64
+	IsInaccurate bool
+	IsOld        bool
+	LastUpdate   time.Time
+	Latitude     float64
+	Longitude    float64
+	PositionType string
+}
+
+type DeviceRole struct {
+	Emoji      string
+	Identifier int
+	Name       string
+}
+
+type DeviceSafeLocation struct {
+	Address    string
+	Identifier string
+	Latitude   float64
+	Longitude  float64
+	Name       string
+}
+
+// NewDevice creates a device. The ID is derived from the Apple identifier when
+// one is given, so it survives renames and duplicated names, and from the
+// display name otherwise. The ObjectID is always derived from the name.
+func NewDevice(address, batteryStatus string, gpsAccuracy float64, identifier string, lastUpdate time.Time, latitude, longitude float64, modelName, name, sourceType string) *Device {
+	objectID := generateDeviceID(name)
+	id := objectID
+	if identifier != "" {
+		id = generateDeviceID(identifier)
+	}
 	return &Device{
 		Address:       address,
 		BatteryStatus: batteryStatus,
 		GPSAccuracy:   gpsAccuracy,
-		ID:            generateDeviceID(name),
+		ID:            id,
 		LastUpdate:    lastUpdate,
 		Latitude:      latitude,
 		Longitude:     longitude,
 		ModelName:     modelName,
 		Name:          name,
+		ObjectID:      objectID,
 		SourceType:    sourceType,
 	}
 }
@@ -0,0 +1,5 @@
+package entities
+
+// DeviceIDMigrationMap maps a device ID computed by the bridge to the ID that
+// must be published instead, e.g. the name based ID of an existing entity.
+type DeviceIDMigrationMap map[string]string
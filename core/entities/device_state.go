@@ -0,0 +1,22 @@
+package entities
+
+import "time"
+
+// DeviceState is what the bridge remembers of a device across scans and
+// restarts. The Last fields describe the last publication, PayloadHash being
+// the hash of what was published apart from the fields compared with the
+// publish thresholds. Zone is nil until the device has been tracked.
+type DeviceState struct {
+	FirstSeen        time.Time  `json:"first_seen"`
+	ID               string     `json:"id"`
+	LastBatteryLevel *float64   `json:"last_battery_level,omitempty"`
+	LastFix          ZoneFix    `json:"last_fix"`
+	LastPublished    time.Time  `json:"last_published"`
+	LastSeen         time.Time  `json:"last_seen"`
+	LastZone         string     `json:"last_zone"`
+	Name             string     `json:"name"`
+	PayloadHash      string     `json:"payload_hash"`
+	Zone             *ZoneState `json:"zone,omitempty"`
+}
+
+type DeviceStateMap map[string]DeviceState
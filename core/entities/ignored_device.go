@@ -0,0 +1,12 @@
+package entities
+
+import "time"
+
+// IgnoredDevice is a device excluded from the bridge on request, and the time
+// it was ignored.
+type IgnoredDevice struct {
+	ID        string    `json:"id"`
+	IgnoredAt time.Time `json:"ignored_at"`
+}
+
+type IgnoredDeviceMap map[string]IgnoredDevice
@@ -1,9 +1,44 @@
 package entities
 
+import "time"
+
+const (
+	Home    = "home"
+	NotHome = "not_home"
+)
+
+// KnownLocation is either a circle, defined by its centre and its tolerance in
+// meters, or a set of polygons, optionally extended by a buffer in meters. The
+// centre of a polygon zone is the average of the vertices of its outer rings.
+//
+// A device enters the zone within Tolerance (or Buffer) and leaves it beyond
+// ExitTolerance (or ExitBuffer), once the new zone was observed for Dwell.
 type KnownLocation struct {
+	Buffer        float64
+	Dwell         time.Duration
+	ExitBuffer    float64
+	ExitTolerance float64
+	Latitude      float64
+	Longitude     float64
+	Polygons      []Polygon
+	Priority      int
+	Tolerance     float64
+}
+
+type KnownLocationMap map[string]KnownLocation
+
+// KnownLocationMatch is a known location containing a position, with the
+// great-circle distance in meters from the position to its centre.
+type KnownLocationMatch struct {
+	Distance float64
+	Name     string
+}
+
+type Coordinate struct {
 	Latitude  float64
 	Longitude float64
-	Tolerance float64
 }
 
-type KnownLocationMap map[string]KnownLocation
+// Polygon is a list of linear rings: the first one is the outer boundary and
+// the others are holes.
+type Polygon [][]Coordinate
@@ -0,0 +1,12 @@
+package entities
+
+import "time"
+
+// PublishThresholds sets the changes of position and battery level under
+// which a device is not published again, and the interval after which it is
+// published anyway.
+type PublishThresholds struct {
+	BatteryChange float64
+	Heartbeat     time.Duration
+	MinDistance   float64
+}
@@ -0,0 +1,12 @@
+package entities
+
+import "time"
+
+// ScanResult sums up a scan: the devices published, the devices skipped as
+// unchanged and the devices that could not be published.
+type ScanResult struct {
+	Duration  time.Duration
+	Failed    int
+	Published int
+	Skipped   int
+}
@@ -0,0 +1,48 @@
+package entities
+
+import "time"
+
+// ZoneState is the zone committed for a device, and the zone it is about to
+// move to while the dwell time of the transition has not elapsed yet.
+type ZoneState struct {
+	CandidateSince time.Time `json:"candidate_since"`
+	CandidateZone  string    `json:"candidate_zone"`
+	Distance       *float64  `json:"distance,omitempty"`
+	Fix            ZoneFix   `json:"fix"`
+	Since          time.Time `json:"since"`
+	Stale          bool      `json:"stale"`
+	Zone           string    `json:"zone"`
+}
+
+// ZoneFix is the fix a zone state or event is based on.
+type ZoneFix struct {
+	GPSAccuracy float64   `json:"gps_accuracy"`
+	LastUpdate  time.Time `json:"last_update"`
+	Latitude    float64   `json:"latitude"`
+	Longitude   float64   `json:"longitude"`
+	Source      string    `json:"source"`
+}
+
+type ZoneEventType string
+
+const (
+	ZoneEventEnter       ZoneEventType = "enter"
+	ZoneEventLeave       ZoneEventType = "leave"
+	ZoneEventMovedWithin ZoneEventType = "moved_within"
+	ZoneEventStale       ZoneEventType = "stale"
+)
+
+// ZoneEvent is a discrete change of the zone state of a device. Dwell is the
+// time spent in PreviousZone for enter and leave events, and in Zone so far
+// for moved_within and stale events.
+type ZoneEvent struct {
+	DeviceID      string
+	Dwell         time.Duration
+	Fix           ZoneFix
+	PreviousSince time.Time
+	PreviousZone  string
+	Since         time.Time
+	Timestamp     time.Time
+	Type          ZoneEventType
+	Zone          string
+}
@@ -1,5 +1,12 @@
 package interfaces
 
+import (
+	"apple-findmy-to-mqtt/core/entities"
+	"context"
+)
+
 type ICacheSyncMQTTController interface {
-	Process(forceSync bool)
+	Process(ctx context.Context, forceSync bool) (entities.ScanResult, error)
+	PublishDiscovery() error
+	RemoveDevice(id string) error
 }
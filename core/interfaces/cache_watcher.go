@@ -0,0 +1,7 @@
+package interfaces
+
+import "context"
+
+type ICacheWatcher interface {
+	Watch(ctx context.Context, onChange func()) error
+}
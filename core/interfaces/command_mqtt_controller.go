@@ -0,0 +1,5 @@
+package interfaces
+
+type ICommandMQTTController interface {
+	Subscribe() error
+}
@@ -6,6 +6,10 @@ import (
 )
 
 type IDeviceUsecase interface {
+	FlushStates() error
+	ForgetDevice(id string)
 	GetDevicesCache() ([]entities.Device, error)
-	HasDeviceMustBeUpdated(id, name string, lastUpdate time.Time) bool
+	GetExpired(now time.Time, gracePeriod time.Duration) []entities.DeviceState
+	MarkPublished(device entities.Device, zone string, content []byte, now time.Time)
+	MustPublish(device entities.Device, zone string, content []byte, thresholds entities.PublishThresholds, now time.Time) bool
 }
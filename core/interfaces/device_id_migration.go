@@ -0,0 +1,8 @@
+package interfaces
+
+import "apple-findmy-to-mqtt/core/entities"
+
+type IDeviceIDMigrationFile interface {
+	LoadMigrationsFromFile(filePath string) (entities.DeviceIDMigrationMap, error)
+	GetAllMigrations() entities.DeviceIDMigrationMap
+}
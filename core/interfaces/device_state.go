@@ -0,0 +1,14 @@
+package interfaces
+
+import "apple-findmy-to-mqtt/core/entities"
+
+// IDeviceStateStore keeps the state of every device. Update creates the state
+// of an unknown device, with its ID set, before passing it to update.
+type IDeviceStateStore interface {
+	Delete(id string)
+	DeleteAll()
+	Flush() error
+	Get(id string) (entities.DeviceState, bool)
+	GetAll() entities.DeviceStateMap
+	Update(id string, update func(state *entities.DeviceState))
+}
@@ -0,0 +1,8 @@
+package interfaces
+
+import "apple-findmy-to-mqtt/core/entities"
+
+type IHassDiscoveryPublisher interface {
+	PublishDevice(device entities.Device, force bool) error
+	RemoveDevice(id string) error
+}
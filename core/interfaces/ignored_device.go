@@ -0,0 +1,17 @@
+package interfaces
+
+import (
+	"apple-findmy-to-mqtt/core/entities"
+	"time"
+)
+
+type IIgnoredDeviceFile interface {
+	LoadDevices() (entities.IgnoredDeviceMap, error)
+	SaveDevices(devices entities.IgnoredDeviceMap) error
+}
+
+type IIgnoredDevicesUsecase interface {
+	Ignore(id string, now time.Time) error
+	IsIgnored(id string) bool
+	Unignore(id string) (bool, error)
+}
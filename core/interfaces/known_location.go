@@ -4,9 +4,16 @@ import "apple-findmy-to-mqtt/core/entities"
 
 type IKnownLocationFile interface {
 	LoadLocationsFromFile(filePath string) (entities.KnownLocationMap, error)
+	LoadLocationsFromGeoJSONFile(filePath string) (entities.KnownLocationMap, error)
 	GetAllLocations() entities.KnownLocationMap
+	OnReload(listener func())
+	Reload() error
 }
 
 type IKnownLocationsUsecase interface {
+	GetLocationDistance(name string, knownLocation entities.KnownLocation) (float64, bool)
+	GetLocationMatch(knownLocation entities.KnownLocation) (entities.KnownLocationMatch, bool)
 	GetLocationName(knownLocation entities.KnownLocation) string
+	OnReload(listener func())
+	Reload() error
 }
@@ -0,0 +1,11 @@
+package interfaces
+
+import "apple-findmy-to-mqtt/core/entities"
+
+// ILocationSource provides device fixes. Every source registered in the
+// "location_sources" group is read on each scan and the fixes are merged per
+// device.
+type ILocationSource interface {
+	Name() string
+	ReadDevices() ([]entities.Device, error)
+}
@@ -1,10 +1,47 @@
 package interfaces
 
 type IMQTTClient interface {
+	ClearQueue() error
 	Connect() error
 	Disconnect()
-	Publish(topic string, payload []byte) error
+	IsConnected() bool
+	Publish(topic string, payload []byte, options PublishOptions) error
 	Subscribe(topic string, handler MessageHandler) error
 }
 
-type MessageHandler func(topic string, payload []byte)
+// IMessage is a message received on a subscription. Retained tells whether
+// the broker replayed it from its retained store rather than relaying it live.
+type IMessage interface {
+	Payload() []byte
+	Retained() bool
+	Topic() string
+}
+
+type MessageHandler func(message IMessage)
+
+type MessageClass string
+
+const (
+	MessageClassAttributes MessageClass = "attributes"
+	MessageClassDiscovery  MessageClass = "discovery"
+	MessageClassEvent      MessageClass = "event"
+	MessageClassState      MessageClass = "state"
+)
+
+// MQTTClientOptions changes how the MQTT client connects. A passive client,
+// such as the one of purge, uses its own client ID so that the broker does
+// not drop the running bridge, and neither announces the availability of the
+// bridge nor delivers its offline queue.
+type MQTTClientOptions struct {
+	ClientIDSuffix string
+	Passive        bool
+}
+
+// PublishOptions sets how a message is published. The class tells how the
+// message is kept while the broker is unreachable: only the latest message
+// of a topic is kept, except for events.
+type PublishOptions struct {
+	Class  MessageClass
+	QoS    byte
+	Retain bool
+}
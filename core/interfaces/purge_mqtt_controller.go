@@ -0,0 +1,7 @@
+package interfaces
+
+import "context"
+
+type IPurgeMQTTController interface {
+	Purge(ctx context.Context) (int, error)
+}
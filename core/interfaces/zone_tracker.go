@@ -0,0 +1,12 @@
+package interfaces
+
+import (
+	"apple-findmy-to-mqtt/core/entities"
+	"time"
+)
+
+type IZoneTrackerUsecase interface {
+	Expire(now time.Time, staleAfter time.Duration) []entities.ZoneEvent
+	GetState(id string) (entities.ZoneState, bool)
+	Track(device entities.Device, now time.Time) (entities.ZoneState, []entities.ZoneEvent)
+}
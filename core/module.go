@@ -7,6 +7,11 @@ import (
 )
 
 var Module = fx.Options(
-	fx.Provide(usecases.NewDeviceUsecase),
+	fx.Provide(fx.Annotate(
+		usecases.NewDeviceUsecase,
+		fx.ParamTags(``, ``, `group:"location_sources"`),
+	)),
+	fx.Provide(usecases.NewIgnoredDevicesUsecase),
 	fx.Provide(usecases.NewKnownLocationsUsecase),
+	fx.Provide(usecases.NewZoneTrackerUsecase),
 )
@@ -3,23 +3,182 @@ package usecases
 import (
 	"apple-findmy-to-mqtt/core/entities"
 	"apple-findmy-to-mqtt/core/interfaces"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
 	"time"
 )
 
+// fixFreshnessTolerance is the age difference under which two fixes of the
+// same device are considered equally fresh and the most accurate one wins.
+const fixFreshnessTolerance = 30 * time.Second
+
 type deviceUsecase struct {
-	fileCacheReader interfaces.IFileCacheReader
+	deviceIDMigrationFile interfaces.IDeviceIDMigrationFile
+	deviceStateStore      interfaces.IDeviceStateStore
+	locationSources       []interfaces.ILocationSource
 }
 
-func NewDeviceUsecase(fileCacheReader interfaces.IFileCacheReader) interfaces.IDeviceUsecase {
+func NewDeviceUsecase(deviceIDMigrationFile interfaces.IDeviceIDMigrationFile, deviceStateStore interfaces.IDeviceStateStore, locationSources []interfaces.ILocationSource) interfaces.IDeviceUsecase {
 	return &deviceUsecase{
-		fileCacheReader: fileCacheReader,
+		deviceIDMigrationFile: deviceIDMigrationFile,
+		deviceStateStore:      deviceStateStore,
+		locationSources:       locationSources,
 	}
 }
 
+// GetDevicesCache reads every location source and keeps the best fix of each
+// device, recording when each device was first and last seen. Devices of the
+// sources that could be read are returned along with the errors of the
+// others.
 func (du *deviceUsecase) GetDevicesCache() ([]entities.Device, error) {
-	return du.fileCacheReader.ReadDevicesData()
+	var devices []entities.Device
+	var errs []error
+	for _, locationSource := range du.locationSources {
+		sourceDevices, err := locationSource.ReadDevices()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("location source %q: %w", locationSource.Name(), err))
+			continue
+		}
+		for _, device := range sourceDevices {
+			if device.Source == "" {
+				device.Source = locationSource.Name()
+			}
+			devices = append(devices, device)
+		}
+	}
+
+	migrations := du.deviceIDMigrationFile.GetAllMigrations()
+	for i := range devices {
+		if id, ok := migrations[devices[i].ID]; ok {
+			devices[i].ID = id
+		}
+	}
+	devices = mergeDevices(devices)
+	now := time.Now()
+	for _, device := range devices {
+		du.deviceStateStore.Update(device.ID, func(state *entities.DeviceState) {
+			if state.FirstSeen.IsZero() {
+				state.FirstSeen = now
+			}
+			state.LastSeen = now
+		})
+	}
+	return devices, errors.Join(errs...)
+}
+
+func (du *deviceUsecase) FlushStates() error {
+	return du.deviceStateStore.Flush()
 }
 
-func (du *deviceUsecase) HasDeviceMustBeUpdated(id, name string, lastUpdate time.Time) bool {
-	return du.fileCacheReader.HasDeviceMustBeUpdated(id, name, lastUpdate)
+// ForgetDevice drops the state of a device, which is then published again
+// as a new device.
+func (du *deviceUsecase) ForgetDevice(id string) {
+	du.deviceStateStore.Delete(id)
+}
+
+// GetExpired returns the devices not seen for more than gracePeriod, sorted by
+// ID.
+func (du *deviceUsecase) GetExpired(now time.Time, gracePeriod time.Duration) []entities.DeviceState {
+	var expired []entities.DeviceState
+	for _, state := range du.deviceStateStore.GetAll() {
+		if !state.LastSeen.IsZero() && now.Sub(state.LastSeen) > gracePeriod {
+			expired = append(expired, state)
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool {
+		return expired[i].ID < expired[j].ID
+	})
+	return expired
+}
+
+// MarkPublished records what was published for a device. content is the
+// published payload without the fields compared with the publish thresholds.
+func (du *deviceUsecase) MarkPublished(device entities.Device, zone string, content []byte, now time.Time) {
+	hash := sha256.Sum256(content)
+	du.deviceStateStore.Update(device.ID, func(state *entities.DeviceState) {
+		state.LastBatteryLevel = device.BatteryLevel
+		state.LastFix = entities.ZoneFix{
+			GPSAccuracy: device.GPSAccuracy,
+			LastUpdate:  device.LastUpdate,
+			Latitude:    device.Latitude,
+			Longitude:   device.Longitude,
+			Source:      device.Source,
+		}
+		state.LastPublished = now
+		state.LastZone = zone
+		state.Name = device.Name
+		state.PayloadHash = hex.EncodeToString(hash[:])
+	})
+}
+
+// MustPublish reports whether a device differs enough from its last
+// publication to be published again: when it is new or renamed, when its
+// content changed, when it changed zone, moved by at least the minimum
+// distance or had its battery level change by at least the battery change, or
+// when the heartbeat interval elapsed. A new fix at the same position is not
+// published.
+func (du *deviceUsecase) MustPublish(device entities.Device, zone string, content []byte, thresholds entities.PublishThresholds, now time.Time) bool {
+	state, exists := du.deviceStateStore.Get(device.ID)
+	if !exists || state.LastPublished.IsZero() || state.Name != device.Name || state.LastZone != zone {
+		return true
+	}
+	if hash := sha256.Sum256(content); state.PayloadHash != hex.EncodeToString(hash[:]) {
+		return true
+	}
+	if thresholds.Heartbeat > 0 && now.Sub(state.LastPublished) >= thresholds.Heartbeat {
+		return true
+	}
+	distance := haversineDistance(state.LastFix.Latitude, state.LastFix.Longitude, device.Latitude, device.Longitude)
+	if distance > 0 && distance >= thresholds.MinDistance {
+		return true
+	}
+	return batteryChanged(state.LastBatteryLevel, device.BatteryLevel, thresholds.BatteryChange)
+}
+
+func batteryChanged(previous, current *float64, threshold float64) bool {
+	if previous == nil || current == nil {
+		return (previous == nil) != (current == nil)
+	}
+	change := math.Abs(*current - *previous)
+	return change > 0 && change >= threshold
+}
+
+// mergeDevices keeps one device per ID, in order of first appearance.
+func mergeDevices(devices []entities.Device) []entities.Device {
+	merged := make([]entities.Device, 0, len(devices))
+	indexes := make(map[string]int, len(devices))
+	for _, device := range devices {
+		i, exists := indexes[device.ID]
+		if !exists {
+			indexes[device.ID] = len(merged)
+			merged = append(merged, device)
+			continue
+		}
+		if isBetterFix(device, merged[i]) {
+			merged[i] = device
+		}
+	}
+	return merged
+}
+
+// isBetterFix reports whether the fix of a is better than the fix of b: the
+// freshest fix wins, unless both are about as fresh, in which case an
+// accurate fix wins over an inaccurate one and then the smallest accuracy
+// radius wins.
+func isBetterFix(a, b entities.Device) bool {
+	age := a.LastUpdate.Sub(b.LastUpdate)
+	if math.Abs(float64(age)) > float64(fixFreshnessTolerance) {
+		return age > 0
+	}
+	if a.IsInaccurate != b.IsInaccurate {
+		return !a.IsInaccurate
+	}
+	if a.GPSAccuracy != b.GPSAccuracy {
+		return a.GPSAccuracy < b.GPSAccuracy
+	}
+	return a.LastUpdate.After(b.LastUpdate)
 }
@@ -0,0 +1,113 @@
+package usecases
+
+import (
+	"apple-findmy-to-mqtt/core/entities"
+	"math"
+)
+
+// earthRadius is the mean radius of the Earth in meters.
+const earthRadius = 6371008.8
+
+// haversineDistance returns the great-circle distance in meters between two
+// points given in decimal degrees.
+func haversineDistance(latitude1, longitude1, latitude2, longitude2 float64) float64 {
+	phi1 := latitude1 * math.Pi / 180
+	phi2 := latitude2 * math.Pi / 180
+	deltaPhi := (latitude2 - latitude1) * math.Pi / 180
+	deltaLambda := (longitude2 - longitude1) * math.Pi / 180
+
+	a := math.Sin(deltaPhi/2)*math.Sin(deltaPhi/2) +
+		math.Cos(phi1)*math.Cos(phi2)*math.Sin(deltaLambda/2)*math.Sin(deltaLambda/2)
+	return 2 * earthRadius * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// polygonContains reports whether a point is inside the polygon, holes
+// excluded.
+func polygonContains(polygon entities.Polygon, point entities.Coordinate) bool {
+	if len(polygon) == 0 || !ringContains(polygon[0], point) {
+		return false
+	}
+	for _, hole := range polygon[1:] {
+		if ringContains(hole, point) {
+			return false
+		}
+	}
+	return true
+}
+
+// ringContains implements the even-odd rule on a linear ring.
+func ringContains(ring []entities.Coordinate, point entities.Coordinate) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a.Latitude > point.Latitude) != (b.Latitude > point.Latitude) &&
+			point.Longitude < (b.Longitude-a.Longitude)*(point.Latitude-a.Latitude)/(b.Latitude-a.Latitude)+a.Longitude {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// polygonDistance returns the distance in meters from a point outside the
+// polygon to its nearest edge, holes included. Distances are computed on a
+// local equirectangular projection centred on the point, which is accurate
+// for the few hundred meters a buffer usually spans.
+func polygonDistance(polygon entities.Polygon, point entities.Coordinate) float64 {
+	distance := math.Inf(1)
+	for _, ring := range polygon {
+		for i := range ring {
+			ax, ay := projectLocal(ring[i], point)
+			bx, by := projectLocal(ring[(i+1)%len(ring)], point)
+			distance = math.Min(distance, segmentDistance(ax, ay, bx, by))
+		}
+	}
+	return distance
+}
+
+func projectLocal(coordinate, origin entities.Coordinate) (float64, float64) {
+	x := (coordinate.Longitude - origin.Longitude) * math.Pi / 180 * math.Cos(origin.Latitude*math.Pi/180) * earthRadius
+	y := (coordinate.Latitude - origin.Latitude) * math.Pi / 180 * earthRadius
+	return x, y
+}
+
+// segmentDistance returns the distance from the origin to the segment AB.
+func segmentDistance(ax, ay, bx, by float64) float64 {
+	dx, dy := bx-ax, by-ay
+	t := 0.0
+	if length := dx*dx + dy*dy; length > 0 {
+		t = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/length))
+	}
+	return math.Hypot(ax+t*dx, ay+t*dy)
+}
+
+// zoneRadius returns the radius of a circle zone, or the radius of the circle
+// having the same area as the polygons of a polygon zone plus its buffer.
+func zoneRadius(location entities.KnownLocation) float64 {
+	if len(location.Polygons) == 0 {
+		return location.Tolerance
+	}
+	centre := entities.Coordinate{Latitude: location.Latitude, Longitude: location.Longitude}
+	area := 0.0
+	for _, polygon := range location.Polygons {
+		for i, ring := range polygon {
+			if i == 0 {
+				area += ringArea(ring, centre)
+			} else {
+				area -= ringArea(ring, centre)
+			}
+		}
+	}
+	return math.Sqrt(math.Max(area, 0)/math.Pi) + location.Buffer
+}
+
+// ringArea returns the area in square meters of a linear ring, using the
+// shoelace formula on a local projection centred on origin.
+func ringArea(ring []entities.Coordinate, origin entities.Coordinate) float64 {
+	area := 0.0
+	for i := range ring {
+		ax, ay := projectLocal(ring[i], origin)
+		bx, by := projectLocal(ring[(i+1)%len(ring)], origin)
+		area += ax*by - bx*ay
+	}
+	return math.Abs(area) / 2
+}
@@ -0,0 +1,82 @@
+package usecases
+
+import (
+	"apple-findmy-to-mqtt/core/entities"
+	"math"
+	"testing"
+)
+
+func TestHaversineDistance(t *testing.T) {
+	tests := []struct {
+		name       string
+		latitude1  float64
+		longitude1 float64
+		latitude2  float64
+		longitude2 float64
+		want       float64
+	}{
+		{name: "same point", latitude1: 48.8584, longitude1: 2.2945, latitude2: 48.8584, longitude2: 2.2945, want: 0},
+		{name: "one degree of latitude", latitude1: 0, longitude1: 0, latitude2: 1, longitude2: 0, want: 111195},
+		{name: "across the antimeridian", latitude1: 0, longitude1: 179.9, latitude2: 0, longitude2: -179.9, want: 22239},
+		{name: "antipodes", latitude1: 0, longitude1: 0, latitude2: 0, longitude2: 180, want: 20015114},
+		{name: "pole to pole", latitude1: 90, longitude1: 0, latitude2: -90, longitude2: 0, want: 20015114},
+		{name: "paris to london", latitude1: 48.8566, longitude1: 2.3522, latitude2: 51.5074, longitude2: -0.1278, want: 343557},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got := haversineDistance(tt.latitude1, tt.longitude1, tt.latitude2, tt.longitude2)
+			if math.Abs(got-tt.want) > 1 {
+				t.Errorf("got %.1f m, want %.0f m", got, tt.want)
+			}
+			if reverse := haversineDistance(tt.latitude2, tt.longitude2, tt.latitude1, tt.longitude1); math.Abs(reverse-got) > 1e-6 {
+				t.Errorf("not symmetric: %.6f and %.6f", got, reverse)
+			}
+		})
+	}
+}
+
+// square returns a closed ring around latitude, longitude, size degrees wide.
+func square(latitude, longitude, size float64) []entities.Coordinate {
+	return []entities.Coordinate{
+		{Latitude: latitude, Longitude: longitude},
+		{Latitude: latitude, Longitude: longitude + size},
+		{Latitude: latitude + size, Longitude: longitude + size},
+		{Latitude: latitude + size, Longitude: longitude},
+		{Latitude: latitude, Longitude: longitude},
+	}
+}
+
+func TestPolygonContains(t *testing.T) {
+	polygon := entities.Polygon{square(48, 2, 0.01), square(48.004, 2.004, 0.002)}
+	tests := []struct {
+		name  string
+		point entities.Coordinate
+		want  bool
+	}{
+		{name: "inside", point: entities.Coordinate{Latitude: 48.002, Longitude: 2.002}, want: true},
+		{name: "outside", point: entities.Coordinate{Latitude: 48.02, Longitude: 2.002}, want: false},
+		{name: "in the hole", point: entities.Coordinate{Latitude: 48.005, Longitude: 2.005}, want: false},
+		{name: "between the hole and the boundary", point: entities.Coordinate{Latitude: 48.005, Longitude: 2.008}, want: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if got := polygonContains(polygon, tt.point); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if polygonContains(entities.Polygon{}, entities.Coordinate{}) {
+		t.Error("an empty polygon contains nothing")
+	}
+}
+
+func TestPolygonDistance(t *testing.T) {
+	polygon := entities.Polygon{square(48, 2, 0.01)}
+	// 0.001 degree of latitude north of the northern edge.
+	point := entities.Coordinate{Latitude: 48.011, Longitude: 2.005}
+	if got := polygonDistance(polygon, point); math.Abs(got-111.2) > 0.5 {
+		t.Errorf("got %.1f m, want 111.2 m", got)
+	}
+}
@@ -0,0 +1,61 @@
+package usecases
+
+import (
+	"apple-findmy-to-mqtt/core/entities"
+	"apple-findmy-to-mqtt/core/interfaces"
+	"sync"
+	"time"
+)
+
+type ignoredDevicesUsecase struct {
+	devices           entities.IgnoredDeviceMap
+	ignoredDeviceFile interfaces.IIgnoredDeviceFile
+	mu                sync.RWMutex
+}
+
+// NewIgnoredDevicesUsecase fails when the file cannot be read, as ignored
+// devices would be published again otherwise.
+func NewIgnoredDevicesUsecase(ignoredDeviceFile interfaces.IIgnoredDeviceFile) (interfaces.IIgnoredDevicesUsecase, error) {
+	devices, err := ignoredDeviceFile.LoadDevices()
+	if err != nil {
+		return nil, err
+	}
+	return &ignoredDevicesUsecase{
+		devices:           devices,
+		ignoredDeviceFile: ignoredDeviceFile,
+	}, nil
+}
+
+func (idu *ignoredDevicesUsecase) Ignore(id string, now time.Time) error {
+	idu.mu.Lock()
+	defer idu.mu.Unlock()
+
+	if _, exists := idu.devices[id]; exists {
+		return nil
+	}
+	idu.devices[id] = entities.IgnoredDevice{
+		ID:        id,
+		IgnoredAt: now,
+	}
+	return idu.ignoredDeviceFile.SaveDevices(idu.devices)
+}
+
+func (idu *ignoredDevicesUsecase) IsIgnored(id string) bool {
+	idu.mu.RLock()
+	defer idu.mu.RUnlock()
+
+	_, exists := idu.devices[id]
+	return exists
+}
+
+// Unignore reports whether the device was ignored.
+func (idu *ignoredDevicesUsecase) Unignore(id string) (bool, error) {
+	idu.mu.Lock()
+	defer idu.mu.Unlock()
+
+	if _, exists := idu.devices[id]; !exists {
+		return false, nil
+	}
+	delete(idu.devices, id)
+	return true, idu.ignoredDeviceFile.SaveDevices(idu.devices)
+}
@@ -3,7 +3,7 @@ package usecases
 import (
 	"apple-findmy-to-mqtt/core/entities"
 	"apple-findmy-to-mqtt/core/interfaces"
-	"math"
+	"sort"
 )
 
 type knownLocationsUsecase struct {
@@ -17,25 +17,95 @@ func NewKnownLocationsUsecase(knownLocationFile interfaces.IKnownLocationFile) i
 }
 
 func (kluc *knownLocationsUsecase) GetLocationName(knownLocation entities.KnownLocation) string {
-	knownLocations := kluc.knownLocationFile.GetAllLocations()
-	for name, location := range knownLocations {
-		tolerance := float64(location.Tolerance)
-		if tolerance == 0 {
-			tolerance = knownLocation.Tolerance
+	if match, ok := kluc.GetLocationMatch(knownLocation); ok {
+		return match.Name
+	}
+	return entities.NotHome
+}
+
+// GetLocationMatch returns the known location containing the given position.
+// The tolerance of the given position is used as radius for circles without
+// one. When known locations overlap, the one with the highest priority wins,
+// then the one with the nearest centre.
+func (kluc *knownLocationsUsecase) GetLocationMatch(knownLocation entities.KnownLocation) (entities.KnownLocationMatch, bool) {
+	var matches []entities.KnownLocationMatch
+	locations := kluc.knownLocationFile.GetAllLocations()
+	for name, location := range locations {
+		distance := haversineDistance(location.Latitude, location.Longitude, knownLocation.Latitude, knownLocation.Longitude)
+		if contains(location, knownLocation, distance) {
+			matches = append(matches, entities.KnownLocationMatch{Distance: distance, Name: name})
+		}
+	}
+	if len(matches) == 0 {
+		return entities.KnownLocationMatch{}, false
+	}
+	return bestMatch(locations, matches), true
+}
+
+// GetLocationDistance returns the distance, in meters, between the given
+// position and the centre of the known location named name.
+func (kluc *knownLocationsUsecase) GetLocationDistance(name string, knownLocation entities.KnownLocation) (float64, bool) {
+	location, ok := kluc.knownLocationFile.GetAllLocations()[name]
+	if !ok {
+		return 0, false
+	}
+	return haversineDistance(location.Latitude, location.Longitude, knownLocation.Latitude, knownLocation.Longitude), true
+}
+
+// bestMatch returns the match with the highest priority, then the nearest
+// centre, then the first name in lexical order.
+func bestMatch(locations entities.KnownLocationMap, matches []entities.KnownLocationMatch) entities.KnownLocationMatch {
+	sort.Slice(matches, func(i, j int) bool {
+		if locations[matches[i].Name].Priority != locations[matches[j].Name].Priority {
+			return locations[matches[i].Name].Priority > locations[matches[j].Name].Priority
 		}
-		tolerance = getLatLngApprox(tolerance)
-		if isClose(location.Latitude, knownLocation.Latitude, tolerance) && isClose(location.Longitude, knownLocation.Longitude, tolerance) {
-			return name
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+		return matches[i].Name < matches[j].Name
+	})
+	return matches[0]
+}
+
+// contains reports whether the position of point is inside location, distance
+// being the distance between the position and the centre of location.
+func contains(location, point entities.KnownLocation, distance float64) bool {
+	tolerance := location.Tolerance
+	if tolerance == 0 {
+		tolerance = point.Tolerance
+	}
+	return within(location, point, distance, tolerance, location.Buffer)
+}
+
+// within reports whether the position of point is inside location, extended
+// to the given tolerance for a circle or to the given buffer for polygons.
+func within(location, point entities.KnownLocation, distance, tolerance, buffer float64) bool {
+	if len(location.Polygons) == 0 {
+		return distance <= tolerance
+	}
+	coordinate := entities.Coordinate{Latitude: point.Latitude, Longitude: point.Longitude}
+	for _, polygon := range location.Polygons {
+		if polygonContains(polygon, coordinate) {
+			return true
+		}
+	}
+	if buffer <= 0 {
+		return false
+	}
+	for _, polygon := range location.Polygons {
+		if polygonDistance(polygon, coordinate) <= buffer {
+			return true
 		}
 	}
-	return "not_home"
+	return false
 }
 
-func getLatLngApprox(meters float64) float64 {
-	return meters / 111111
+// OnReload registers a listener called after the known locations were
+// successfully reloaded.
+func (kluc *knownLocationsUsecase) OnReload(listener func()) {
+	kluc.knownLocationFile.OnReload(listener)
 }
 
-// isClose checks whether two float values are close within a certain tolerance.
-func isClose(a, b, tolerance float64) bool {
-	return math.Abs(a-b) <= tolerance
+func (kluc *knownLocationsUsecase) Reload() error {
+	return kluc.knownLocationFile.Reload()
 }
@@ -0,0 +1,124 @@
+package usecases
+
+import (
+	"apple-findmy-to-mqtt/core/entities"
+	"apple-findmy-to-mqtt/core/interfaces"
+	"testing"
+)
+
+// fakeKnownLocationFile serves fixed known locations. The embedded interface
+// is nil, only GetAllLocations is expected to be called.
+type fakeKnownLocationFile struct {
+	interfaces.IKnownLocationFile
+	locations entities.KnownLocationMap
+}
+
+func (fklf fakeKnownLocationFile) GetAllLocations() entities.KnownLocationMap {
+	return fklf.locations
+}
+
+// north returns a point the given number of meters north of latitude,
+// longitude.
+func north(latitude, longitude, meters float64) entities.KnownLocation {
+	return entities.KnownLocation{Latitude: latitude + meters/111195, Longitude: longitude}
+}
+
+func TestGetLocationMatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		locations entities.KnownLocationMap
+		point     entities.KnownLocation
+		want      string
+	}{
+		{
+			name:      "outside",
+			locations: entities.KnownLocationMap{"home": {Latitude: 48.85, Longitude: 2.29, Tolerance: 100}},
+			point:     north(48.85, 2.29, 150),
+			want:      entities.NotHome,
+		},
+		{
+			name:      "inside",
+			locations: entities.KnownLocationMap{"home": {Latitude: 48.85, Longitude: 2.29, Tolerance: 100}},
+			point:     north(48.85, 2.29, 50),
+			want:      "home",
+		},
+		{
+			name: "highest priority wins over the nearest centre",
+			locations: entities.KnownLocationMap{
+				"home":   {Latitude: 48.85, Longitude: 2.29, Tolerance: 200},
+				"garden": {Latitude: 48.85 + 100/111195.0, Longitude: 2.29, Priority: 1, Tolerance: 200},
+			},
+			point: north(48.85, 2.29, 10),
+			want:  "garden",
+		},
+		{
+			name: "nearest centre wins on equal priority",
+			locations: entities.KnownLocationMap{
+				"home":   {Latitude: 48.85, Longitude: 2.29, Tolerance: 200},
+				"garden": {Latitude: 48.85 + 100/111195.0, Longitude: 2.29, Tolerance: 200},
+			},
+			point: north(48.85, 2.29, 60),
+			want:  "garden",
+		},
+		{
+			name: "first name wins on equal priority and distance",
+			locations: entities.KnownLocationMap{
+				"b": {Latitude: 48.85, Longitude: 2.29, Tolerance: 100},
+				"a": {Latitude: 48.85, Longitude: 2.29, Tolerance: 100},
+			},
+			point: north(48.85, 2.29, 10),
+			want:  "a",
+		},
+		{
+			name:      "tolerance of the position for a location without one",
+			locations: entities.KnownLocationMap{"home": {Latitude: 48.85, Longitude: 2.29}},
+			point:     entities.KnownLocation{Latitude: 48.85 + 50/111195.0, Longitude: 2.29, Tolerance: 70},
+			want:      "home",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			kluc := NewKnownLocationsUsecase(fakeKnownLocationFile{locations: tt.locations})
+			if got := kluc.GetLocationName(tt.point); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetLocationMatchPolygons(t *testing.T) {
+	// Two squares of about 1.1 km, the first one with a hole, 0.02 degree
+	// apart.
+	park := entities.KnownLocation{
+		Buffer:    50,
+		Latitude:  48.005,
+		Longitude: 2.015,
+		Polygons: []entities.Polygon{
+			{square(48, 2, 0.01), square(48.004, 2.004, 0.002)},
+			{square(48, 2.03, 0.01)},
+		},
+	}
+	locations := entities.KnownLocationMap{"park": park}
+	tests := []struct {
+		name  string
+		point entities.KnownLocation
+		want  string
+	}{
+		{name: "first polygon", point: entities.KnownLocation{Latitude: 48.002, Longitude: 2.002}, want: "park"},
+		{name: "second polygon", point: entities.KnownLocation{Latitude: 48.002, Longitude: 2.035}, want: "park"},
+		{name: "between the polygons", point: entities.KnownLocation{Latitude: 48.005, Longitude: 2.02}, want: entities.NotHome},
+		{name: "in the hole", point: entities.KnownLocation{Latitude: 48.005, Longitude: 2.005}, want: entities.NotHome},
+		{name: "within the buffer", point: north(48.01, 2.005, 30), want: "park"},
+		{name: "beyond the buffer", point: north(48.01, 2.005, 80), want: entities.NotHome},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			kluc := NewKnownLocationsUsecase(fakeKnownLocationFile{locations: locations})
+			if got := kluc.GetLocationName(tt.point); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package usecases
+
+import (
+	"apple-findmy-to-mqtt/core/entities"
+	"apple-findmy-to-mqtt/core/interfaces"
+	"sort"
+	"sync"
+	"time"
+)
+
+type zoneTrackerUsecase struct {
+	deviceStateStore  interfaces.IDeviceStateStore
+	knownLocationFile interfaces.IKnownLocationFile
+	mu                sync.Mutex
+}
+
+// NewZoneTrackerUsecase keeps the zone states in the device state store, so
+// that transitions that happened while the bridge was stopped are reported.
+func NewZoneTrackerUsecase(deviceStateStore interfaces.IDeviceStateStore, knownLocationFile interfaces.IKnownLocationFile) interfaces.IZoneTrackerUsecase {
+	return &zoneTrackerUsecase{
+		deviceStateStore:  deviceStateStore,
+		knownLocationFile: knownLocationFile,
+	}
+}
+
+// Track updates the zone committed for a device with its latest fix and
+// returns the events it caused. A device enters a zone within the enter
+// radius and leaves it beyond the exit radius, and a transition is only
+// committed once the new zone has been observed for the dwell time of the zone
+// entered, or left when the device leaves for no zone. Fixes less accurate
+// than the radius of a zone can neither enter nor leave that zone. The first
+// fix of a device sets its zone without any event.
+func (ztu *zoneTrackerUsecase) Track(device entities.Device, now time.Time) (entities.ZoneState, []entities.ZoneEvent) {
+	ztu.mu.Lock()
+	defer ztu.mu.Unlock()
+
+	locations := ztu.knownLocationFile.GetAllLocations()
+	point := entities.KnownLocation{Latitude: device.Latitude, Longitude: device.Longitude}
+	fix := entities.ZoneFix{
+		GPSAccuracy: device.GPSAccuracy,
+		LastUpdate:  device.LastUpdate,
+		Latitude:    device.Latitude,
+		Longitude:   device.Longitude,
+		Source:      device.Source,
+	}
+	var previous entities.ZoneState
+	deviceState, exists := ztu.deviceStateStore.Get(device.ID)
+	exists = exists && deviceState.Zone != nil
+	if exists {
+		previous = *deviceState.Zone
+	}
+	if _, known := locations[previous.Zone]; exists && previous.Zone != entities.NotHome && !known {
+		exists = false
+	}
+
+	var state entities.ZoneState
+	var events []entities.ZoneEvent
+	if !exists {
+		state = entities.ZoneState{
+			Since: now,
+			Zone:  enterZone(locations, point, device.GPSAccuracy),
+		}
+	} else {
+		state = previous
+		if candidate, ok := nextZone(locations, previous.Zone, point, device.GPSAccuracy); ok {
+			state = transition(locations, previous, candidate, now)
+		}
+		events = zoneEvents(device.ID, previous, state, fix, now)
+	}
+
+	state.Fix = fix
+	state.Stale = exists && previous.Stale && !fix.LastUpdate.After(previous.Fix.LastUpdate)
+	if device.IsOld && !state.Stale {
+		state.Stale = true
+		events = append(events, staleEvent(device.ID, state, now))
+	}
+	state.Distance = nil
+	if location, ok := locations[state.Zone]; ok {
+		distance := haversineDistance(location.Latitude, location.Longitude, point.Latitude, point.Longitude)
+		state.Distance = &distance
+	}
+	ztu.deviceStateStore.Update(device.ID, func(deviceState *entities.DeviceState) {
+		deviceState.Zone = &state
+	})
+	return state, events
+}
+
+// Expire returns a stale event for every device whose last fix is older than
+// staleAfter, once per fix.
+func (ztu *zoneTrackerUsecase) Expire(now time.Time, staleAfter time.Duration) []entities.ZoneEvent {
+	ztu.mu.Lock()
+	defer ztu.mu.Unlock()
+
+	var events []entities.ZoneEvent
+	for id, deviceState := range ztu.deviceStateStore.GetAll() {
+		if deviceState.Zone == nil {
+			continue
+		}
+		state := *deviceState.Zone
+		if state.Stale || now.Sub(state.Fix.LastUpdate) <= staleAfter {
+			continue
+		}
+		state.Stale = true
+		ztu.deviceStateStore.Update(id, func(deviceState *entities.DeviceState) {
+			deviceState.Zone = &state
+		})
+		events = append(events, staleEvent(id, state, now))
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].DeviceID < events[j].DeviceID
+	})
+	return events
+}
+
+func (ztu *zoneTrackerUsecase) GetState(id string) (entities.ZoneState, bool) {
+	deviceState, exists := ztu.deviceStateStore.Get(id)
+	if !exists || deviceState.Zone == nil {
+		return entities.ZoneState{}, false
+	}
+	return *deviceState.Zone, true
+}
+
+// zoneEvents returns the events of a device going from previous to state:
+// leave and enter when the committed zone changed, not_home being neither
+// entered nor left, and moved_within when the device moved inside its zone
+// without heading to another one.
+func zoneEvents(id string, previous, state entities.ZoneState, fix entities.ZoneFix, now time.Time) []entities.ZoneEvent {
+	event := entities.ZoneEvent{
+		DeviceID:      id,
+		Dwell:         now.Sub(previous.Since),
+		Fix:           fix,
+		PreviousSince: previous.Since,
+		PreviousZone:  previous.Zone,
+		Since:         state.Since,
+		Timestamp:     now,
+		Zone:          state.Zone,
+	}
+	if state.Zone != previous.Zone {
+		var events []entities.ZoneEvent
+		if previous.Zone != entities.NotHome {
+			event.Type = entities.ZoneEventLeave
+			events = append(events, event)
+		}
+		if state.Zone != entities.NotHome {
+			event.Type = entities.ZoneEventEnter
+			events = append(events, event)
+		}
+		return events
+	}
+	if state.Zone == entities.NotHome || state.CandidateZone != "" || (fix.Latitude == previous.Fix.Latitude && fix.Longitude == previous.Fix.Longitude) {
+		return nil
+	}
+	event.Type = entities.ZoneEventMovedWithin
+	return []entities.ZoneEvent{event}
+}
+
+func staleEvent(id string, state entities.ZoneState, now time.Time) entities.ZoneEvent {
+	return entities.ZoneEvent{
+		DeviceID:      id,
+		Dwell:         now.Sub(state.Since),
+		Fix:           state.Fix,
+		PreviousSince: state.Since,
+		PreviousZone:  state.Zone,
+		Since:         state.Since,
+		Timestamp:     now,
+		Type:          entities.ZoneEventStale,
+		Zone:          state.Zone,
+	}
+}
+
+// transition moves the state towards candidate, committing it once it has
+// been observed for the dwell time.
+func transition(locations entities.KnownLocationMap, state entities.ZoneState, candidate string, now time.Time) entities.ZoneState {
+	if candidate == state.Zone {
+		state.CandidateZone = ""
+		state.CandidateSince = time.Time{}
+		return state
+	}
+	if state.CandidateZone != candidate {
+		state.CandidateZone = candidate
+		state.CandidateSince = now
+	}
+	dwell := locations[candidate].Dwell
+	if candidate == entities.NotHome {
+		dwell = locations[state.Zone].Dwell
+	}
+	if now.Sub(state.CandidateSince) >= dwell {
+		return entities.ZoneState{
+			Since: now,
+			Zone:  candidate,
+		}
+	}
+	return state
+}
+
+// nextZone returns the zone the fix points to, given the committed zone. It
+// returns false when the fix is too inaccurate to leave the committed zone.
+func nextZone(locations entities.KnownLocationMap, zone string, point entities.KnownLocation, accuracy float64) (string, bool) {
+	if location, ok := locations[zone]; ok {
+		if accuracy > zoneRadius(location) {
+			return "", false
+		}
+		distance := haversineDistance(location.Latitude, location.Longitude, point.Latitude, point.Longitude)
+		if within(location, point, distance, location.ExitTolerance, location.ExitBuffer) {
+			return zone, true
+		}
+	}
+	return enterZone(locations, point, accuracy), true
+}
+
+// enterZone returns the zone whose enter radius contains the fix, ignoring the
+// zones smaller than the accuracy of the fix. Overlaps are resolved as in
+// GetLocationMatch.
+func enterZone(locations entities.KnownLocationMap, point entities.KnownLocation, accuracy float64) string {
+	var matches []entities.KnownLocationMatch
+	for name, location := range locations {
+		if accuracy > zoneRadius(location) {
+			continue
+		}
+		distance := haversineDistance(location.Latitude, location.Longitude, point.Latitude, point.Longitude)
+		if within(location, point, distance, location.Tolerance, location.Buffer) {
+			matches = append(matches, entities.KnownLocationMatch{Distance: distance, Name: name})
+		}
+	}
+	if len(matches) == 0 {
+		return entities.NotHome
+	}
+	return bestMatch(locations, matches).Name
+}
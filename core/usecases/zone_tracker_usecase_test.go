@@ -0,0 +1,171 @@
+package usecases
+
+import (
+	"apple-findmy-to-mqtt/core/entities"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testZones is a home circle with a hysteresis of 50 m and a dwell time of a
+// minute, and a small shop circle with no exit margin and no dwell time.
+var testZones = entities.KnownLocationMap{
+	"home": {Dwell: time.Minute, ExitTolerance: 150, Latitude: 48.85, Longitude: 2.29, Tolerance: 100},
+	"shop": {ExitTolerance: 20, Latitude: 48.86, Longitude: 2.29, Tolerance: 20},
+}
+
+func TestEnterZone(t *testing.T) {
+	tests := []struct {
+		name     string
+		point    entities.KnownLocation
+		accuracy float64
+		want     string
+	}{
+		{name: "inside", point: north(48.85, 2.29, 50), accuracy: 10, want: "home"},
+		{name: "between the enter and the exit radius", point: north(48.85, 2.29, 120), accuracy: 10, want: entities.NotHome},
+		{name: "accuracy larger than the zone", point: north(48.86, 2.29, 5), accuracy: 30, want: entities.NotHome},
+		{name: "accuracy equal to the zone", point: north(48.86, 2.29, 5), accuracy: 20, want: "shop"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if got := enterZone(testZones, tt.point, tt.accuracy); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextZone(t *testing.T) {
+	tests := []struct {
+		name     string
+		zone     string
+		point    entities.KnownLocation
+		accuracy float64
+		want     string
+		wantOk   bool
+	}{
+		{name: "stays within the exit radius", zone: "home", point: north(48.85, 2.29, 120), accuracy: 10, want: "home", wantOk: true},
+		{name: "leaves beyond the exit radius", zone: "home", point: north(48.85, 2.29, 200), accuracy: 10, want: entities.NotHome, wantOk: true},
+		{name: "leaves for another zone", zone: "home", point: north(48.86, 2.29, 5), accuracy: 10, want: "shop", wantOk: true},
+		{name: "too inaccurate to leave", zone: "shop", point: north(48.85, 2.29, 0), accuracy: 50, want: "", wantOk: false},
+		{name: "enters from not_home", zone: entities.NotHome, point: north(48.85, 2.29, 50), accuracy: 10, want: "home", wantOk: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := nextZone(testZones, tt.zone, tt.point, tt.accuracy)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("got %q, %v, want %q, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestTransition(t *testing.T) {
+	t0 := time.Date(2024, 5, 2, 8, 0, 0, 0, time.UTC)
+	home := entities.ZoneState{Since: t0.Add(-time.Hour), Zone: "home"}
+
+	// Leaving home waits for the dwell time of home.
+	state := transition(testZones, home, entities.NotHome, t0)
+	if state.Zone != "home" || state.CandidateZone != entities.NotHome || !state.CandidateSince.Equal(t0) {
+		t.Fatalf("first fix outside: got %+v", state)
+	}
+	state = transition(testZones, state, entities.NotHome, t0.Add(30*time.Second))
+	if state.Zone != "home" || !state.CandidateSince.Equal(t0) {
+		t.Fatalf("before the dwell time: got %+v", state)
+	}
+	state = transition(testZones, state, entities.NotHome, t0.Add(time.Minute))
+	if state.Zone != entities.NotHome || state.CandidateZone != "" || !state.Since.Equal(t0.Add(time.Minute)) {
+		t.Fatalf("after the dwell time: got %+v", state)
+	}
+
+	// Coming back before the dwell time cancels the transition.
+	state = transition(testZones, home, entities.NotHome, t0)
+	state = transition(testZones, state, "home", t0.Add(30*time.Second))
+	if state.Zone != "home" || state.CandidateZone != "" || !state.CandidateSince.IsZero() || !state.Since.Equal(home.Since) {
+		t.Fatalf("back home: got %+v", state)
+	}
+
+	// Entering a zone without dwell time commits at once.
+	state = transition(testZones, home, "shop", t0)
+	if state.Zone != "shop" || !state.Since.Equal(t0) {
+		t.Fatalf("shop: got %+v", state)
+	}
+}
+
+func TestZoneEvents(t *testing.T) {
+	t0 := time.Date(2024, 5, 2, 8, 0, 0, 0, time.UTC)
+	fix := entities.ZoneFix{Latitude: 48.85, Longitude: 2.29}
+	moved := entities.ZoneFix{Latitude: 48.8501, Longitude: 2.29}
+	tests := []struct {
+		name     string
+		previous entities.ZoneState
+		state    entities.ZoneState
+		fix      entities.ZoneFix
+		want     []entities.ZoneEventType
+	}{
+		{
+			name:     "home to not_home",
+			previous: entities.ZoneState{Fix: fix, Zone: "home"},
+			state:    entities.ZoneState{Since: t0, Zone: entities.NotHome},
+			fix:      moved,
+			want:     []entities.ZoneEventType{entities.ZoneEventLeave},
+		},
+		{
+			name:     "not_home to home",
+			previous: entities.ZoneState{Fix: fix, Zone: entities.NotHome},
+			state:    entities.ZoneState{Since: t0, Zone: "home"},
+			fix:      moved,
+			want:     []entities.ZoneEventType{entities.ZoneEventEnter},
+		},
+		{
+			name:     "home to shop",
+			previous: entities.ZoneState{Fix: fix, Zone: "home"},
+			state:    entities.ZoneState{Since: t0, Zone: "shop"},
+			fix:      moved,
+			want:     []entities.ZoneEventType{entities.ZoneEventLeave, entities.ZoneEventEnter},
+		},
+		{
+			name:     "moved within home",
+			previous: entities.ZoneState{Fix: fix, Zone: "home"},
+			state:    entities.ZoneState{Zone: "home"},
+			fix:      moved,
+			want:     []entities.ZoneEventType{entities.ZoneEventMovedWithin},
+		},
+		{
+			name:     "same fix",
+			previous: entities.ZoneState{Fix: fix, Zone: "home"},
+			state:    entities.ZoneState{Zone: "home"},
+			fix:      fix,
+		},
+		{
+			name:     "heading to another zone",
+			previous: entities.ZoneState{Fix: fix, Zone: "home"},
+			state:    entities.ZoneState{CandidateZone: entities.NotHome, Zone: "home"},
+			fix:      moved,
+		},
+		{
+			name:     "moved while not_home",
+			previous: entities.ZoneState{Fix: fix, Zone: entities.NotHome},
+			state:    entities.ZoneState{Zone: entities.NotHome},
+			fix:      moved,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			events := zoneEvents("airtag", tt.previous, tt.state, tt.fix, t0)
+			var got []entities.ZoneEventType
+			for _, event := range events {
+				got = append(got, event.Type)
+				if event.DeviceID != "airtag" || event.PreviousZone != tt.previous.Zone || event.Zone != tt.state.Zone {
+					t.Errorf("unexpected event %+v", event)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
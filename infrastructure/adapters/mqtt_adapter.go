@@ -3,62 +3,443 @@ package adapters
 import (
 	"apple-findmy-to-mqtt/core/interfaces"
 	"apple-findmy-to-mqtt/infrastructure/config"
-	"bytes"
+	"apple-findmy-to-mqtt/infrastructure/logging"
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	MQTT "github.com/eclipse/paho.mqtt.golang"
 	"go.uber.org/fx"
 )
 
+const (
+	mqttAvailabilityOffline = "offline"
+	mqttAvailabilityOnline  = "online"
+	mqttAvailabilityQoS     = 1
+	mqttSubscribeQoS        = 1
+)
+
+const (
+	mqttConnectTimeout       = 10 * time.Second
+	mqttConnectRetryInterval = 5 * time.Second
+	mqttDisconnectQuiesce    = time.Second
+	mqttDrainPollInterval    = 100 * time.Millisecond
+	mqttMaxReconnectInterval = 2 * time.Minute
+	mqttPublishTimeout       = 10 * time.Second
+	mqttSubscribeTimeout     = 10 * time.Second
+)
+
 type MqttClientParams struct {
 	fx.In
-	Config config.Config
+	Config    config.Config
+	Lifecycle fx.Lifecycle
+	Logger    logging.Logger
+	Options   interfaces.MQTTClientOptions `optional:"true"`
 }
 type pahoMQTTClient struct {
-	client MQTT.Client
+	availabilityTopic string
+	broker            string
+	client            MQTT.Client
+	draining          atomic.Bool
+	logger            logging.Logger
+	passive           bool
+	queue             *offlineQueue
+	queuePath         string
+	statsTopic        string
+	subscriptions     map[string]MQTT.MessageHandler
+	subscriptionsMu   sync.Mutex
 }
 
-func NewPahoMQTTClient(mcp MqttClientParams) interfaces.IMQTTClient {
-	tlsConfig := &tls.Config{
-		InsecureSkipVerify: true,
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func NewPahoMQTTClient(mcp MqttClientParams) (interfaces.IMQTTClient, error) {
+	const names = "__mqtt_adapter.go__: NewPahoMQTTClient"
+	broker, err := brokerURL(mcp.Config.Mqtt)
+	if err != nil {
+		return nil, err
 	}
-	var raw_broker bytes.Buffer
-	raw_broker.WriteString("tls://")
-	raw_broker.WriteString(fmt.Sprintf("%s:%d", mcp.Config.Mqtt.Broker, mcp.Config.Mqtt.Port))
-	opts := MQTT.NewClientOptions().AddBroker(raw_broker.String())
-	opts.SetClientID(mcp.Config.Mqtt.ClientID)
+	opts := MQTT.NewClientOptions().AddBroker(broker)
+	opts.SetClientID(mcp.Config.Mqtt.ClientID + mcp.Options.ClientIDSuffix)
 	opts.SetUsername(mcp.Config.Mqtt.Username)
 	opts.SetPassword(mcp.Config.Mqtt.Password)
-	opts.SetTLSConfig(tlsConfig)
+	opts.SetAutoReconnect(true)
+	opts.SetMaxReconnectInterval(mqttMaxReconnectInterval)
+	opts.SetConnectRetry(true)
+	opts.SetConnectRetryInterval(mqttConnectRetryInterval)
+	availabilityTopic := mcp.Config.Mqtt.BridgeAvailabilityTopic()
+	if !mcp.Options.Passive {
+		opts.SetWill(availabilityTopic, mqttAvailabilityOffline, mqttAvailabilityQoS, true)
+	}
+	if scheme := brokerScheme(mcp.Config.Mqtt); scheme == config.MqttSchemeSSL || scheme == config.MqttSchemeWSS {
+		tlsConfig, err := newTLSConfig(mcp.Config.Mqtt.TLS)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	pqc := &pahoMQTTClient{
+		availabilityTopic: availabilityTopic,
+		broker:            broker,
+		logger:            mcp.Logger,
+		passive:           mcp.Options.Passive,
+		queuePath:         mcp.Config.Mqtt.Queue.Path,
+		statsTopic:        fmt.Sprintf("%s/bridge/queue", mcp.Config.Mqtt.Topic),
+		subscriptions:     make(map[string]MQTT.MessageHandler),
+	}
+	if queue := mcp.Config.Mqtt.Queue; queue.Path != "" && !pqc.passive {
+		pqc.queue, err = newOfflineQueue(queue.Path, queue.MaxMessages, time.Duration(queue.MaxAge)*time.Second)
+		if err != nil {
+			return nil, fmt.Errorf("mqtt: %w", err)
+		}
+		if length := pqc.queue.Len(); length > 0 {
+			pqc.logger.Info(fmt.Sprintf("%s | %d messages queued while offline", names, length))
+		}
+	}
+	opts.SetOnConnectHandler(pqc.onConnect)
+	opts.SetConnectionLostHandler(pqc.onConnectionLost)
+	opts.SetReconnectingHandler(pqc.onReconnecting)
+	pqc.client = MQTT.NewClient(opts)
+
+	mcp.Lifecycle.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			if err := pqc.Connect(); err != nil {
+				pqc.logger.Warn(fmt.Sprintf("%s | %s", names, err.Error()))
+			}
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			pqc.waitDrained(ctx)
+			pqc.Disconnect()
+			return nil
+		},
+	})
+	return pqc, nil
+}
+
+// brokerScheme defaults to ssl, as previous versions always connected with
+// tls://, so that an upgrade never falls back to plaintext.
+func brokerScheme(mqtt config.Mqtt) string {
+	if mqtt.Scheme == "" {
+		return config.MqttSchemeSSL
+	}
+	return strings.ToLower(mqtt.Scheme)
+}
+
+// brokerURL builds the broker URL from the scheme, host, port and, for
+// websockets, path of the configuration.
+func brokerURL(mqtt config.Mqtt) (string, error) {
+	scheme := brokerScheme(mqtt)
+	switch scheme {
+	case config.MqttSchemeTCP, config.MqttSchemeSSL, config.MqttSchemeWS, config.MqttSchemeWSS:
+	default:
+		return "", fmt.Errorf("mqtt: unsupported scheme %q, expected tcp, ssl, ws or wss", mqtt.Scheme)
+	}
+	if mqtt.Broker == "" {
+		return "", fmt.Errorf("mqtt: broker is not set")
+	}
+	broker := url.URL{
+		Scheme: scheme,
+		Host:   fmt.Sprintf("%s:%d", mqtt.Broker, mqtt.Port),
+	}
+	if scheme == config.MqttSchemeWS || scheme == config.MqttSchemeWSS {
+		broker.Path = "/" + strings.TrimPrefix(mqtt.Path, "/")
+	}
+	return broker.String(), nil
+}
 
-	return &pahoMQTTClient{
-		client: MQTT.NewClient(opts),
+// newTLSConfig builds the TLS configuration of the connection. The system
+// roots are used when no CA bundle is configured.
+func newTLSConfig(mqttTLS config.MqttTLS) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: mqttTLS.Insecure,
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         mqttTLS.ServerName,
+	}
+	if mqttTLS.MinVersion != "" {
+		version, ok := tlsVersions[mqttTLS.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("mqtt tls: unsupported min_version %q, expected 1.0, 1.1, 1.2 or 1.3", mqttTLS.MinVersion)
+		}
+		tlsConfig.MinVersion = version
 	}
+	if mqttTLS.CAFile != "" {
+		pem, err := os.ReadFile(mqttTLS.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("mqtt tls: error reading CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("mqtt tls: no PEM certificate found in CA bundle %s", mqttTLS.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if mqttTLS.CertFile != "" || mqttTLS.KeyFile != "" {
+		if mqttTLS.CertFile == "" || mqttTLS.KeyFile == "" {
+			return nil, fmt.Errorf("mqtt tls: cert_file and key_file must be set together")
+		}
+		certificate, err := tls.LoadX509KeyPair(mqttTLS.CertFile, mqttTLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("mqtt tls: error loading client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
 }
 
+// Connect starts connecting to the broker and waits for the first attempt.
+// Failed attempts are retried in the background, so an unreachable broker
+// only delays publishing.
 func (pqc *pahoMQTTClient) Connect() error {
-	if token := pqc.client.Connect(); token.Wait() && token.Error() != nil {
-		return token.Error()
+	const names = "__mqtt_adapter.go__: Connect"
+	token := pqc.client.Connect()
+	if !token.WaitTimeout(mqttConnectTimeout) {
+		pqc.logger.Warn(fmt.Sprintf("%s | Broker %s not reachable yet, retrying in the background", names, pqc.broker))
+		return nil
+	}
+	if token.Error() != nil {
+		return fmt.Errorf("mqtt: error connecting to %s: %w", pqc.broker, token.Error())
+	}
+	return nil
+}
+
+// ClearQueue drops every message of the offline queue, including the ones
+// persisted by a bridge that is not running.
+func (pqc *pahoMQTTClient) ClearQueue() error {
+	if pqc.queue != nil {
+		return pqc.queue.Clear()
+	}
+	if pqc.queuePath == "" {
+		return nil
+	}
+	if err := os.Remove(pqc.queuePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("mqtt: error clearing offline queue: %w", err)
 	}
 	return nil
 }
 
+// Disconnect marks the bridge offline, as the will is only sent by the broker
+// when the connection is lost, and closes the connection.
 func (pqc *pahoMQTTClient) Disconnect() {
-	pqc.client.Disconnect(250)
+	const names = "__mqtt_adapter.go__: Disconnect"
+	if pqc.IsConnected() && !pqc.passive {
+		if err := pqc.publishAvailability(mqttAvailabilityOffline); err != nil {
+			pqc.logger.Warn(fmt.Sprintf("%s | %s", names, err.Error()))
+		}
+	}
+	pqc.client.Disconnect(uint(mqttDisconnectQuiesce.Milliseconds()))
 }
 
-func (pqc *pahoMQTTClient) Publish(topic string, payload []byte) error {
-	token := pqc.client.Publish(topic, 0, false, payload)
-	token.Wait()
+func (pqc *pahoMQTTClient) IsConnected() bool {
+	return pqc.client.IsConnectionOpen()
+}
+
+// Publish publishes a message, or queues it when the broker is unreachable or
+// older messages are still queued, so that messages are delivered in order.
+func (pqc *pahoMQTTClient) Publish(topic string, payload []byte, options interfaces.PublishOptions) error {
+	const names = "__mqtt_adapter.go__: Publish"
+	if options.QoS > 2 {
+		return fmt.Errorf("mqtt: invalid QoS %d for %s messages", options.QoS, options.Class)
+	}
+	if pqc.queue == nil {
+		return pqc.publish(topic, payload, options.QoS, options.Retain)
+	}
+	if pqc.IsConnected() && pqc.queue.Len() == 0 {
+		err := pqc.publish(topic, payload, options.QoS, options.Retain)
+		if err == nil {
+			return nil
+		}
+		pqc.logger.Warn(fmt.Sprintf("%s | %s, queueing the message", names, err.Error()))
+	}
+	if err := pqc.enqueue(topic, payload, options); err != nil {
+		return err
+	}
+	pqc.drain()
+	return nil
+}
+
+func (pqc *pahoMQTTClient) enqueue(topic string, payload []byte, options interfaces.PublishOptions) error {
+	const names = "__mqtt_adapter.go__: enqueue"
+	dropped, err := pqc.queue.Push(queuedMessage{
+		Append:  options.Class == interfaces.MessageClassEvent,
+		Payload: payload,
+		QoS:     options.QoS,
+		Retain:  options.Retain,
+		Topic:   topic,
+	}, time.Now())
+	if dropped > 0 {
+		stats := pqc.queue.Stats()
+		pqc.logger.Warn(fmt.Sprintf("%s | Offline queue full, dropped %d messages (%d since start)", names, dropped, stats.DroppedFull))
+	}
+	if err != nil {
+		return fmt.Errorf("mqtt: %w", err)
+	}
+	return nil
+}
+
+// drain publishes the queued messages in order while the broker is
+// connected. Only one drain runs at a time.
+func (pqc *pahoMQTTClient) drain() {
+	if pqc.queue == nil || !pqc.IsConnected() || !pqc.draining.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		const names = "__mqtt_adapter.go__: drain"
+		for {
+			delivered := pqc.drainQueue()
+			pqc.draining.Store(false)
+			if delivered > 0 {
+				stats := pqc.queue.Stats()
+				pqc.logger.Info(fmt.Sprintf("%s | Delivered %d queued messages, %d left, %d dropped when full, %d expired", names, delivered, stats.Queued, stats.DroppedFull, stats.DroppedExpired))
+				if statsJSON, err := json.Marshal(stats); err == nil {
+					if err := pqc.publish(pqc.statsTopic, statsJSON, 0, false); err != nil {
+						pqc.logger.Warn(fmt.Sprintf("%s | %s", names, err.Error()))
+					}
+				}
+			}
+			// A message queued while the flag was still set would wait for
+			// the next drain otherwise.
+			if pqc.queue.Len() == 0 || !pqc.IsConnected() || !pqc.draining.CompareAndSwap(false, true) {
+				return
+			}
+		}
+	}()
+}
+
+// waitDrained delivers the queued messages before stopping, leaving time
+// before ctx is done for the other stop hooks and the disconnection. The
+// messages left are delivered on the next start.
+func (pqc *pahoMQTTClient) waitDrained(ctx context.Context) {
+	const names = "__mqtt_adapter.go__: waitDrained"
+	if pqc.queue == nil {
+		return
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline.Add(-2*mqttDisconnectQuiesce))
+		defer cancel()
+	}
+	pqc.drain()
+	ticker := time.NewTicker(mqttDrainPollInterval)
+	defer ticker.Stop()
+	for pqc.IsConnected() && (pqc.queue.Len() > 0 || pqc.draining.Load()) {
+		select {
+		case <-ctx.Done():
+			pqc.logger.Warn(fmt.Sprintf("%s | %d messages left in the offline queue", names, pqc.queue.Len()))
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (pqc *pahoMQTTClient) drainQueue() int {
+	const names = "__mqtt_adapter.go__: drainQueue"
+	delivered := 0
+	for pqc.IsConnected() {
+		message, ok, err := pqc.queue.Peek(time.Now())
+		if err != nil {
+			pqc.logger.Warn(fmt.Sprintf("%s | %s", names, err.Error()))
+		}
+		if !ok {
+			break
+		}
+		if err := pqc.publish(message.Topic, message.Payload, message.QoS, message.Retain); err != nil {
+			pqc.logger.Warn(fmt.Sprintf("%s | %s", names, err.Error()))
+			break
+		}
+		if err := pqc.queue.Remove(message.Seq); err != nil {
+			pqc.logger.Warn(fmt.Sprintf("%s | %s", names, err.Error()))
+		}
+		delivered++
+	}
+	return delivered
+}
+
+func (pqc *pahoMQTTClient) publish(topic string, payload []byte, qos byte, retain bool) error {
+	token := pqc.client.Publish(topic, qos, retain, payload)
+	if !token.WaitTimeout(mqttPublishTimeout) {
+		return fmt.Errorf("mqtt: timeout publishing to %s", topic)
+	}
+	return token.Error()
+}
+
+func (pqc *pahoMQTTClient) publishAvailability(availability string) error {
+	token := pqc.client.Publish(pqc.availabilityTopic, mqttAvailabilityQoS, true, availability)
+	if !token.WaitTimeout(mqttPublishTimeout) {
+		return fmt.Errorf("mqtt: timeout publishing to %s", pqc.availabilityTopic)
+	}
 	return token.Error()
 }
 
+// Subscribe subscribes to topic now if the broker is connected, and again on
+// every reconnection, as the session of the bridge is not kept by the broker.
 func (pqc *pahoMQTTClient) Subscribe(topic string, handler interfaces.MessageHandler) error {
-	if token := pqc.client.Subscribe(topic, 0, func(client MQTT.Client, message MQTT.Message) {
-		handler(message.Topic(), message.Payload())
-	}); token.Wait() && token.Error() != nil {
-		return token.Error()
+	callback := func(client MQTT.Client, message MQTT.Message) {
+		handler(message)
+	}
+	pqc.subscriptionsMu.Lock()
+	pqc.subscriptions[topic] = callback
+	pqc.subscriptionsMu.Unlock()
+	if !pqc.IsConnected() {
+		return nil
+	}
+	return pqc.subscribe(topic, callback)
+}
+
+func (pqc *pahoMQTTClient) subscribe(topic string, callback MQTT.MessageHandler) error {
+	token := pqc.client.Subscribe(topic, mqttSubscribeQoS, callback)
+	if !token.WaitTimeout(mqttSubscribeTimeout) {
+		return fmt.Errorf("mqtt: timeout subscribing to %s", topic)
+	}
+	if token.Error() != nil {
+		return fmt.Errorf("mqtt: error subscribing to %s: %w", topic, token.Error())
 	}
 	return nil
 }
+
+func (pqc *pahoMQTTClient) onConnect(MQTT.Client) {
+	const names = "__mqtt_adapter.go__: onConnect"
+	pqc.logger.Info(fmt.Sprintf("%s | Connected to %s", names, pqc.broker))
+	if !pqc.passive {
+		if err := pqc.publishAvailability(mqttAvailabilityOnline); err != nil {
+			pqc.logger.Warn(fmt.Sprintf("%s | %s", names, err.Error()))
+		}
+	}
+	pqc.subscriptionsMu.Lock()
+	subscriptions := make(map[string]MQTT.MessageHandler, len(pqc.subscriptions))
+	for topic, callback := range pqc.subscriptions {
+		subscriptions[topic] = callback
+	}
+	pqc.subscriptionsMu.Unlock()
+	for topic, callback := range subscriptions {
+		if err := pqc.subscribe(topic, callback); err != nil {
+			pqc.logger.Warn(fmt.Sprintf("%s | %s", names, err.Error()))
+		}
+	}
+	pqc.drain()
+}
+
+func (pqc *pahoMQTTClient) onConnectionLost(_ MQTT.Client, err error) {
+	const names = "__mqtt_adapter.go__: onConnectionLost"
+	pqc.logger.Warn(fmt.Sprintf("%s | Connection to %s lost: %s", names, pqc.broker, err.Error()))
+}
+
+func (pqc *pahoMQTTClient) onReconnecting(MQTT.Client, *MQTT.ClientOptions) {
+	const names = "__mqtt_adapter.go__: onReconnecting"
+	pqc.logger.Warn(fmt.Sprintf("%s | Reconnecting to %s", names, pqc.broker))
+}
@@ -0,0 +1,287 @@
+package adapters
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// offlineQueue keeps the messages that could not be published while the
+// broker was unreachable, in order, and persists them to disk so that they
+// survive a restart. A message of a coalesced topic replaces the queued
+// message of the same topic, as only the latest state matters, while appended
+// messages, such as events, are all kept. The oldest messages are dropped
+// when the queue is full or when they are older than the maximum age.
+//
+// The file is an append-only log of JSON records, one per line, so that every
+// change costs a single small write. It is compacted into the messages still
+// queued when the log grows well beyond them.
+type offlineQueue struct {
+	maxAge      time.Duration
+	maxMessages int
+	messages    []queuedMessage
+	mu          sync.Mutex
+	path        string
+	records     int
+	seq         uint64
+	stats       offlineQueueStats
+}
+
+// offlineQueueCompactRecords is the number of records the log may hold beyond
+// twice the queued messages before it is compacted.
+const offlineQueueCompactRecords = 1000
+
+// queueRecord is a line of the log: a message queued, the sequence numbers of
+// the messages removed, or both when queueing a message replaced or dropped
+// others.
+type queueRecord struct {
+	Message *queuedMessage `json:"message,omitempty"`
+	Remove  []uint64       `json:"remove,omitempty"`
+}
+
+type queuedMessage struct {
+	Append   bool      `json:"append"`
+	Payload  []byte    `json:"payload"`
+	QoS      byte      `json:"qos"`
+	QueuedAt time.Time `json:"queued_at"`
+	Retain   bool      `json:"retain"`
+	Seq      uint64    `json:"seq"`
+	Topic    string    `json:"topic"`
+}
+
+type offlineQueueStats struct {
+	Delivered      uint64 `json:"delivered"`
+	DroppedExpired uint64 `json:"dropped_expired"`
+	DroppedFull    uint64 `json:"dropped_full"`
+	Queued         int    `json:"queued"`
+}
+
+// newOfflineQueue returns the queue persisted at path, empty when the file
+// does not exist yet. The log is compacted on load.
+func newOfflineQueue(path string, maxMessages int, maxAge time.Duration) (*offlineQueue, error) {
+	oq := &offlineQueue{
+		maxAge:      maxAge,
+		maxMessages: maxMessages,
+		path:        path,
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return oq, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading offline queue: %w", err)
+	}
+	if err := oq.load(data); err != nil {
+		return nil, fmt.Errorf("error parsing offline queue %s: %w", path, err)
+	}
+	for _, message := range oq.messages {
+		if message.Seq > oq.seq {
+			oq.seq = message.Seq
+		}
+	}
+	if err := oq.compact(); err != nil {
+		return nil, err
+	}
+	return oq, nil
+}
+
+// load replays the records of the log. A last line cut by a crash is ignored.
+func (oq *offlineQueue) load(data []byte) error {
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		var record queueRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			if i == len(lines)-1 {
+				break
+			}
+			return fmt.Errorf("line %d: %w", i+1, err)
+		}
+		oq.removeSeqs(record.Remove)
+		if record.Message != nil {
+			oq.messages = append(oq.messages, *record.Message)
+		}
+	}
+	return nil
+}
+
+func (oq *offlineQueue) Len() int {
+	oq.mu.Lock()
+	defer oq.mu.Unlock()
+	return len(oq.messages)
+}
+
+// Push queues a message and returns the number of messages dropped to make
+// room for it.
+func (oq *offlineQueue) Push(message queuedMessage, now time.Time) (int, error) {
+	oq.mu.Lock()
+	defer oq.mu.Unlock()
+
+	var removed []uint64
+	if !message.Append {
+		for i, queued := range oq.messages {
+			if !queued.Append && queued.Topic == message.Topic {
+				removed = append(removed, queued.Seq)
+				oq.messages = append(oq.messages[:i], oq.messages[i+1:]...)
+				break
+			}
+		}
+	}
+	oq.seq++
+	message.QueuedAt = now
+	message.Seq = oq.seq
+	oq.messages = append(oq.messages, message)
+	dropped := 0
+	if oq.maxMessages > 0 && len(oq.messages) > oq.maxMessages {
+		dropped = len(oq.messages) - oq.maxMessages
+		for _, queued := range oq.messages[:dropped] {
+			removed = append(removed, queued.Seq)
+		}
+		oq.messages = oq.messages[dropped:]
+		oq.stats.DroppedFull += uint64(dropped)
+	}
+	return dropped, oq.log(queueRecord{Message: &message, Remove: removed})
+}
+
+// Peek returns the oldest message of the queue, after dropping the expired
+// ones.
+func (oq *offlineQueue) Peek(now time.Time) (queuedMessage, bool, error) {
+	oq.mu.Lock()
+	defer oq.mu.Unlock()
+
+	var err error
+	if oq.maxAge > 0 {
+		expired := 0
+		for expired < len(oq.messages) && now.Sub(oq.messages[expired].QueuedAt) > oq.maxAge {
+			expired++
+		}
+		if expired > 0 {
+			removed := make([]uint64, expired)
+			for i, message := range oq.messages[:expired] {
+				removed[i] = message.Seq
+			}
+			oq.messages = oq.messages[expired:]
+			oq.stats.DroppedExpired += uint64(expired)
+			err = oq.log(queueRecord{Remove: removed})
+		}
+	}
+	if len(oq.messages) == 0 {
+		return queuedMessage{}, false, err
+	}
+	return oq.messages[0], true, err
+}
+
+// Remove removes a delivered message. A message replaced in the meantime is
+// already gone.
+func (oq *offlineQueue) Remove(seq uint64) error {
+	oq.mu.Lock()
+	defer oq.mu.Unlock()
+
+	oq.stats.Delivered++
+	if !oq.removeSeqs([]uint64{seq}) {
+		return nil
+	}
+	return oq.log(queueRecord{Remove: []uint64{seq}})
+}
+
+// removeSeqs removes the messages with the given sequence numbers and returns
+// whether any was queued.
+func (oq *offlineQueue) removeSeqs(seqs []uint64) bool {
+	if len(seqs) == 0 {
+		return false
+	}
+	remove := make(map[uint64]struct{}, len(seqs))
+	for _, seq := range seqs {
+		remove[seq] = struct{}{}
+	}
+	messages := oq.messages[:0]
+	for _, message := range oq.messages {
+		if _, ok := remove[message.Seq]; !ok {
+			messages = append(messages, message)
+		}
+	}
+	removed := len(messages) < len(oq.messages)
+	oq.messages = messages
+	return removed
+}
+
+// Clear drops every queued message.
+func (oq *offlineQueue) Clear() error {
+	oq.mu.Lock()
+	defer oq.mu.Unlock()
+
+	oq.messages = nil
+	return oq.compact()
+}
+
+func (oq *offlineQueue) Stats() offlineQueueStats {
+	oq.mu.Lock()
+	defer oq.mu.Unlock()
+	stats := oq.stats
+	stats.Queued = len(oq.messages)
+	return stats
+}
+
+// log appends a record to the file, or compacts the log when it grew well
+// beyond the queued messages or the queue is empty.
+func (oq *offlineQueue) log(record queueRecord) error {
+	oq.records++
+	if len(oq.messages) == 0 || oq.records > 2*len(oq.messages)+offlineQueueCompactRecords {
+		return oq.compact()
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(oq.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("error saving offline queue: %w", err)
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return fmt.Errorf("error saving offline queue: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error saving offline queue: %w", err)
+	}
+	return nil
+}
+
+// compact rewrites the log with one record per queued message, to a temporary
+// file renamed over the previous one so that a crash never leaves a truncated
+// queue behind.
+func (oq *offlineQueue) compact() error {
+	var buffer bytes.Buffer
+	for i := range oq.messages {
+		data, err := json.Marshal(queueRecord{Message: &oq.messages[i]})
+		if err != nil {
+			return err
+		}
+		buffer.Write(data)
+		buffer.WriteByte('\n')
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(oq.path), filepath.Base(oq.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error saving offline queue: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buffer.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error saving offline queue: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error saving offline queue: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), oq.path); err != nil {
+		return fmt.Errorf("error saving offline queue: %w", err)
+	}
+	oq.records = len(oq.messages)
+	return nil
+}
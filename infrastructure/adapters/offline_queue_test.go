@@ -0,0 +1,172 @@
+package adapters
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2024, 5, 2, 8, 0, 0, 0, time.UTC)
+
+func newTestOfflineQueue(t *testing.T, path string, maxMessages int, maxAge time.Duration) *offlineQueue {
+	t.Helper()
+	oq, err := newOfflineQueue(path, maxMessages, maxAge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return oq
+}
+
+func push(t *testing.T, oq *offlineQueue, topic, payload string, appendOnly bool) int {
+	t.Helper()
+	dropped, err := oq.Push(queuedMessage{Append: appendOnly, Payload: []byte(payload), Topic: topic}, testNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dropped
+}
+
+// queuedPayloads returns the topic and payload of every queued message, in
+// order.
+func queuedPayloads(oq *offlineQueue) []string {
+	var payloads []string
+	for _, message := range oq.messages {
+		payloads = append(payloads, message.Topic+"="+string(message.Payload))
+	}
+	return payloads
+}
+
+func TestOfflineQueueCoalescing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+	oq := newTestOfflineQueue(t, path, 0, 0)
+	push(t, oq, "a/state", "1", false)
+	push(t, oq, "a/event", "enter", true)
+	push(t, oq, "b/state", "1", false)
+	push(t, oq, "a/state", "2", false)
+	push(t, oq, "a/event", "leave", true)
+
+	want := []string{"a/event=enter", "b/state=1", "a/state=2", "a/event=leave"}
+	if got := queuedPayloads(oq); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	reloaded := newTestOfflineQueue(t, path, 0, 0)
+	if got := queuedPayloads(reloaded); !reflect.DeepEqual(got, want) {
+		t.Fatalf("reloaded: got %v, want %v", got, want)
+	}
+}
+
+func TestOfflineQueueLimits(t *testing.T) {
+	oq := newTestOfflineQueue(t, filepath.Join(t.TempDir(), "queue.json"), 2, time.Hour)
+	push(t, oq, "a/event", "1", true)
+	push(t, oq, "a/event", "2", true)
+	if dropped := push(t, oq, "a/event", "3", true); dropped != 1 {
+		t.Fatalf("dropped %d messages, want 1", dropped)
+	}
+	if got, want := queuedPayloads(oq), []string{"a/event=2", "a/event=3"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	if _, ok, err := oq.Peek(testNow.Add(2 * time.Hour)); ok || err != nil {
+		t.Fatalf("expired messages were not dropped: %v, %v", ok, err)
+	}
+	if oq.Len() != 0 {
+		t.Fatalf("got %d messages, want 0", oq.Len())
+	}
+}
+
+func TestOfflineQueueRemove(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+	oq := newTestOfflineQueue(t, path, 0, 0)
+	push(t, oq, "a/state", "1", false)
+	push(t, oq, "b/state", "1", false)
+	message, ok, err := oq.Peek(testNow)
+	if !ok || err != nil {
+		t.Fatalf("nothing to peek: %v", err)
+	}
+	if err := oq.Remove(message.Seq); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := newTestOfflineQueue(t, path, 0, 0)
+	if got, want := queuedPayloads(reloaded), []string{"b/state=1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	// Sequence numbers keep growing after a reload.
+	push(t, reloaded, "c/state", "1", false)
+	if last := reloaded.messages[len(reloaded.messages)-1]; last.Seq <= message.Seq+1 {
+		t.Fatalf("sequence number %d reused", last.Seq)
+	}
+}
+
+func TestOfflineQueueCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+	oq := newTestOfflineQueue(t, path, 0, 0)
+	push(t, oq, "a/event", "first", true)
+	for i := 0; i < 2*offlineQueueCompactRecords; i++ {
+		push(t, oq, "a/state", strings.Repeat("x", i%10), false)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines > offlineQueueCompactRecords+2*oq.Len()+1 {
+		t.Fatalf("the log holds %d records for %d messages", lines, oq.Len())
+	}
+	reloaded := newTestOfflineQueue(t, path, 0, 0)
+	if !reflect.DeepEqual(queuedPayloads(reloaded), queuedPayloads(oq)) {
+		t.Fatalf("got %v, want %v", queuedPayloads(reloaded), queuedPayloads(oq))
+	}
+	data, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines != reloaded.Len() {
+		t.Fatalf("the log holds %d records after loading %d messages", lines, reloaded.Len())
+	}
+
+	// Emptying the queue truncates the log.
+	for oq.Len() > 0 {
+		message, _, _ := oq.Peek(testNow)
+		if err := oq.Remove(message.Seq); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != 0 {
+		t.Fatalf("the log was not truncated: %v", err)
+	}
+}
+
+func TestOfflineQueueTruncatedLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+	oq := newTestOfflineQueue(t, path, 0, 0)
+	push(t, oq, "a/state", "1", false)
+	push(t, oq, "b/state", "1", false)
+
+	// A crash while appending leaves a partial last line.
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteString(`{"message":{"topic":"c/st`); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	reloaded := newTestOfflineQueue(t, path, 0, 0)
+	if got, want := queuedPayloads(reloaded), []string{"a/state=1", "b/state=1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	// A corrupted line in the middle of the log is an error.
+	if err := os.WriteFile(path, []byte("{not json}\n{\"remove\":[1]}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newOfflineQueue(path, 0, 0); err == nil {
+		t.Fatal("expected an error")
+	}
+}
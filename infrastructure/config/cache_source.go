@@ -0,0 +1,55 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	cacheFileExt         = ".data"
+	devicesCacheFileName = "Devices.data"
+	itemsCacheFileName   = "Items.data"
+)
+
+// FilePath expands "~" and environment variables in the source path and, when
+// it points to a directory, appends the cache file name that matches the
+// source type. A path that does not exist yet is taken as a directory unless
+// it names a ".data" file, so that the cache is found once FindMy creates the
+// directory.
+func (cs CacheSource) FilePath() (string, error) {
+	var fileName string
+	switch cs.Type {
+	case CacheSourceTypeDevices:
+		fileName = devicesCacheFileName
+	case CacheSourceTypeItems:
+		fileName = itemsCacheFileName
+	default:
+		return "", fmt.Errorf("cache source %q: unknown type %q, expected %q or %q", cs.Name, cs.Type, CacheSourceTypeDevices, CacheSourceTypeItems)
+	}
+
+	filePath := os.ExpandEnv(cs.Path)
+	if filePath == "~" || strings.HasPrefix(filePath, "~/") {
+		usr, err := user.Current()
+		if err != nil {
+			return "", fmt.Errorf("cache source %q: error getting current user: %w", cs.Name, err)
+		}
+		filePath = filepath.Join(usr.HomeDir, strings.TrimPrefix(filePath, "~"))
+	}
+	if filePath == "" {
+		return "", fmt.Errorf("cache source %q: empty path", cs.Name)
+	}
+
+	info, err := os.Stat(filePath)
+	switch {
+	case err == nil && info.IsDir():
+		filePath = filepath.Join(filePath, fileName)
+	case errors.Is(err, fs.ErrNotExist) && filepath.Ext(filePath) != cacheFileExt:
+		filePath = filepath.Join(filePath, fileName)
+	}
+	return filePath, nil
+}
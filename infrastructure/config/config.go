@@ -17,39 +17,144 @@ var (
 	envPath      string
 	globalConfig *Config
 	ENV_DEFAULT  = map[string]any{
+		"CLEANUP_GRACE_PERIOD":              604800,
 		"DEBUG":                             true,
+		"DEVICE_AVAILABILITY_TIMEOUT":       0,
+		"FINDMY_CACHE_KEY":                  "",
+		"FINDMY_CACHE_KEY_PATH":             "",
+		"FORCE_SYNC":                        false,
+		"DEVICE_ID_MIGRATIONS_PATH":         "device_id_migrations.json",
+		"DEVICE_STATE_PATH":                 "device_state.json",
+		"DEVICE_ID_STRATEGY":                DeviceIDStrategyStable,
 		"ENVIRONMENT":                       "development",
 		"GO_ENV":                            "development",
+		"HASS_ENTITY_ADDRESS":               true,
+		"HASS_ENTITY_BATTERY_LEVEL":         true,
+		"HASS_ENTITY_BATTERY_STATUS":        true,
+		"HASS_ENTITY_GPS_ACCURACY":          true,
+		"HASS_ENTITY_HOME_DISTANCE":         true,
+		"HASS_ENTITY_LAST_UPDATE":           true,
+		"HASS_ENTITY_STALE":                 true,
+		"HASS_ENTITY_ZONE":                  true,
+		"HTTP_PUSH_LISTEN":                  "",
+		"HTTP_PUSH_TOKEN":                   "",
+		"IGNORED_DEVICES_PATH":              "ignored_devices.json",
 		"KNOWN_LOCATIONS_DEFAULT_TOLERANCE": 70,
+		"KNOWN_LOCATIONS_DWELL_TIME":        60,
+		"KNOWN_LOCATIONS_EXIT_MARGIN":       30,
+		"KNOWN_LOCATIONS_GEOJSON_PATH":      "",
 		"KNOWN_LOCATIONS_PATH":              "known_locations.json",
 		"LOG_LEVEL":                         "info",
 		"LOG_OUTPUT":                        "./logs/development.log",
 		"MQTT_PORT":                         1883,
+		"PUBLISH_BATTERY_CHANGE":            1,
+		"PUBLISH_HEARTBEAT":                 3600,
+		"PUBLISH_MIN_DISTANCE":              10,
+		"REPLAY_PATH":                       "",
+		"MQTT_ATTRIBUTES_QOS":               1,
+		"MQTT_ATTRIBUTES_RETAIN":            true,
+		"MQTT_AVAILABILITY_TOPIC":           "",
 		"MQTT_CLIENT_ID":                    "apple_findmy_to_mqtt",
+		"MQTT_DISCOVERY_QOS":                1,
+		"MQTT_DISCOVERY_RETAIN":             true,
+		"MQTT_EVENTS_QOS":                   1,
+		"MQTT_EVENTS_RETAIN":                false,
+		"MQTT_PASSWORD":                     "",
+		"MQTT_PATH":                         "",
+		"MQTT_QUEUE_MAX_AGE":                86400,
+		"MQTT_QUEUE_MAX_MESSAGES":           10000,
+		"MQTT_QUEUE_PATH":                   "mqtt_queue.json",
+		"MQTT_SCHEME":                       MqttSchemeSSL,
+		"MQTT_STATE_QOS":                    1,
+		"MQTT_STATE_RETAIN":                 true,
+		"MQTT_TLS_CA_FILE":                  "",
+		"MQTT_TLS_CERT_FILE":                "",
+		"MQTT_TLS_INSECURE":                 false,
+		"MQTT_TLS_KEY_FILE":                 "",
+		"MQTT_TLS_MIN_VERSION":              "1.2",
+		"MQTT_TLS_SERVER_NAME":              "",
+		"MQTT_USERNAME":                     "",
+		"SCAN_CONCURRENCY":                  4,
+		"SCAN_MODE":                         ScanModeTicker,
+		"SCAN_TIMEOUT":                      120,
 		"SCAN_TIMER":                        5,
+		"SHUTDOWN_TIMEOUT":                  15,
 		"TZ":                                "Europe/Paris",
+		"WATCH_DEBOUNCE":                    500,
+		"WATCH_POLL_INTERVAL":               2,
+		"ZONE_STALE_AFTER":                  3600,
 	}
 )
 
 type Config struct {
-	Environment                    string         `json:"environment"`
-	ForceSync                      bool           `json:"force_sync"`
-	KnownLocationsDefaultTolerance int            `json:"known_locations_default_tolerance"`
-	KnownLocationsPath             string         `json:"known_locations_path"`
-	Loggers                        []LoggerConfig `json:"loggers"`
-	LogLevel                       string         `json:"log_level"`
-	LogOutput                      string         `json:"log_output"`
-	Mqtt                           Mqtt           `json:"mqtt"`
-	ScanTimer                      int            `json:"scan_timer"`
-	TZ                             string         `json:"tz"`
+	CacheKey                       string          `json:"cache_key"`
+	CacheKeyPath                   string          `json:"cache_key_path"`
+	CacheSources                   []CacheSource   `json:"cache_sources"`
+	CleanupGracePeriod             int             `json:"cleanup_grace_period"`
+	DeviceAvailabilityTimeout      int             `json:"device_availability_timeout"`
+	DeviceIDMigrationsPath         string          `json:"device_id_migrations_path"`
+	DeviceIDStrategy               string          `json:"device_id_strategy"`
+	DeviceStatePath                string          `json:"device_state_path"`
+	Environment                    string          `json:"environment"`
+	ForceSync                      bool            `json:"force_sync"`
+	HassEntities                   HassEntities    `json:"hass_entities"`
+	IgnoredDevicesPath             string          `json:"ignored_devices_path"`
+	KnownLocationsDefaultTolerance int             `json:"known_locations_default_tolerance"`
+	KnownLocationsDwellTime        int             `json:"known_locations_dwell_time"`
+	KnownLocationsExitMargin       int             `json:"known_locations_exit_margin"`
+	KnownLocationsGeoJSONPath      string          `json:"known_locations_geojson_path"`
+	KnownLocationsPath             string          `json:"known_locations_path"`
+	LocationSources                LocationSources `json:"location_sources"`
+	Loggers                        []LoggerConfig  `json:"loggers"`
+	LogLevel                       string          `json:"log_level"`
+	LogOutput                      string          `json:"log_output"`
+	Mqtt                           Mqtt            `json:"mqtt"`
+	PublishBatteryChange           int             `json:"publish_battery_change"`
+	PublishHeartbeat               int             `json:"publish_heartbeat"`
+	PublishMinDistance             int             `json:"publish_min_distance"`
+	ScanConcurrency                int             `json:"scan_concurrency"`
+	ScanMode                       string          `json:"scan_mode"`
+	ScanTimeout                    int             `json:"scan_timeout"`
+	ScanTimer                      int             `json:"scan_timer"`
+	ShutdownTimeout                int             `json:"shutdown_timeout"`
+	TZ                             string          `json:"tz"`
+	WatchDebounce                  int             `json:"watch_debounce"`
+	WatchPollInterval              int             `json:"watch_poll_interval"`
+	ZoneStaleAfter                 int             `json:"zone_stale_after"`
+
+	unresolved []string
 }
 
+const (
+	DeviceIDStrategyStable = "stable"
+	DeviceIDStrategyName   = "name"
+)
+
+const (
+	ScanModeTicker = "ticker"
+	ScanModeWatch  = "watch"
+	ScanModePoll   = "poll"
+)
+
 func (c *Config) UnmarshalJSON(data []byte) error {
 	type AliasConfig Config
 	alias := &struct {
 		ScanTimer                      string `json:"scan_timer"`
+		ScanConcurrency                string `json:"scan_concurrency"`
+		ScanTimeout                    string `json:"scan_timeout"`
+		ShutdownTimeout                string `json:"shutdown_timeout"`
+		CleanupGracePeriod             string `json:"cleanup_grace_period"`
+		DeviceAvailabilityTimeout      string `json:"device_availability_timeout"`
 		ForceSync                      string `json:"force_sync"`
 		KnownLocationsDefaultTolerance string `json:"known_locations_default_tolerance"`
+		KnownLocationsDwellTime        string `json:"known_locations_dwell_time"`
+		KnownLocationsExitMargin       string `json:"known_locations_exit_margin"`
+		PublishBatteryChange           string `json:"publish_battery_change"`
+		PublishHeartbeat               string `json:"publish_heartbeat"`
+		PublishMinDistance             string `json:"publish_min_distance"`
+		WatchDebounce                  string `json:"watch_debounce"`
+		WatchPollInterval              string `json:"watch_poll_interval"`
+		ZoneStaleAfter                 string `json:"zone_stale_after"`
 		*AliasConfig
 	}{
 		AliasConfig: (*AliasConfig)(c),
@@ -63,23 +168,127 @@ func (c *Config) UnmarshalJSON(data []byte) error {
 		val := getEnvValue(strings.ToUpper(alias.ScanTimer))
 		scanTimer, err := strconv.ParseInt(val, 10, 0)
 		if err != nil {
-			return err
+			return fmt.Errorf("%s: %w", strings.ToUpper(alias.ScanTimer), err)
 		}
 		c.ScanTimer = int(scanTimer)
 	}
+	if alias.ScanConcurrency != "" {
+		val := getEnvValue(strings.ToUpper(alias.ScanConcurrency))
+		scanConcurrency, err := strconv.ParseInt(val, 10, 0)
+		if err != nil {
+			return fmt.Errorf("%s: %w", strings.ToUpper(alias.ScanConcurrency), err)
+		}
+		c.ScanConcurrency = int(scanConcurrency)
+	}
+	if alias.ScanTimeout != "" {
+		val := getEnvValue(strings.ToUpper(alias.ScanTimeout))
+		scanTimeout, err := strconv.ParseInt(val, 10, 0)
+		if err != nil {
+			return fmt.Errorf("%s: %w", strings.ToUpper(alias.ScanTimeout), err)
+		}
+		c.ScanTimeout = int(scanTimeout)
+	}
+	if alias.ShutdownTimeout != "" {
+		val := getEnvValue(strings.ToUpper(alias.ShutdownTimeout))
+		shutdownTimeout, err := strconv.ParseInt(val, 10, 0)
+		if err != nil {
+			return fmt.Errorf("%s: %w", strings.ToUpper(alias.ShutdownTimeout), err)
+		}
+		c.ShutdownTimeout = int(shutdownTimeout)
+	}
+	if alias.CleanupGracePeriod != "" {
+		val := getEnvValue(strings.ToUpper(alias.CleanupGracePeriod))
+		cleanupGracePeriod, err := strconv.ParseInt(val, 10, 0)
+		if err != nil {
+			return fmt.Errorf("%s: %w", strings.ToUpper(alias.CleanupGracePeriod), err)
+		}
+		c.CleanupGracePeriod = int(cleanupGracePeriod)
+	}
+	if alias.DeviceAvailabilityTimeout != "" {
+		val := getEnvValue(strings.ToUpper(alias.DeviceAvailabilityTimeout))
+		deviceAvailabilityTimeout, err := strconv.ParseInt(val, 10, 0)
+		if err != nil {
+			return fmt.Errorf("%s: %w", strings.ToUpper(alias.DeviceAvailabilityTimeout), err)
+		}
+		c.DeviceAvailabilityTimeout = int(deviceAvailabilityTimeout)
+	}
 	if alias.KnownLocationsDefaultTolerance != "" {
 		val := getEnvValue(strings.ToUpper(alias.KnownLocationsDefaultTolerance))
 		knownLocationsDefaultTolerance, err := strconv.ParseInt(val, 10, 0)
 		if err != nil {
-			return err
+			return fmt.Errorf("%s: %w", strings.ToUpper(alias.KnownLocationsDefaultTolerance), err)
 		}
 		c.KnownLocationsDefaultTolerance = int(knownLocationsDefaultTolerance)
 	}
+	if alias.KnownLocationsDwellTime != "" {
+		val := getEnvValue(strings.ToUpper(alias.KnownLocationsDwellTime))
+		knownLocationsDwellTime, err := strconv.ParseInt(val, 10, 0)
+		if err != nil {
+			return fmt.Errorf("%s: %w", strings.ToUpper(alias.KnownLocationsDwellTime), err)
+		}
+		c.KnownLocationsDwellTime = int(knownLocationsDwellTime)
+	}
+	if alias.KnownLocationsExitMargin != "" {
+		val := getEnvValue(strings.ToUpper(alias.KnownLocationsExitMargin))
+		knownLocationsExitMargin, err := strconv.ParseInt(val, 10, 0)
+		if err != nil {
+			return fmt.Errorf("%s: %w", strings.ToUpper(alias.KnownLocationsExitMargin), err)
+		}
+		c.KnownLocationsExitMargin = int(knownLocationsExitMargin)
+	}
+	if alias.PublishBatteryChange != "" {
+		val := getEnvValue(strings.ToUpper(alias.PublishBatteryChange))
+		publishBatteryChange, err := strconv.ParseInt(val, 10, 0)
+		if err != nil {
+			return fmt.Errorf("%s: %w", strings.ToUpper(alias.PublishBatteryChange), err)
+		}
+		c.PublishBatteryChange = int(publishBatteryChange)
+	}
+	if alias.PublishHeartbeat != "" {
+		val := getEnvValue(strings.ToUpper(alias.PublishHeartbeat))
+		publishHeartbeat, err := strconv.ParseInt(val, 10, 0)
+		if err != nil {
+			return fmt.Errorf("%s: %w", strings.ToUpper(alias.PublishHeartbeat), err)
+		}
+		c.PublishHeartbeat = int(publishHeartbeat)
+	}
+	if alias.PublishMinDistance != "" {
+		val := getEnvValue(strings.ToUpper(alias.PublishMinDistance))
+		publishMinDistance, err := strconv.ParseInt(val, 10, 0)
+		if err != nil {
+			return fmt.Errorf("%s: %w", strings.ToUpper(alias.PublishMinDistance), err)
+		}
+		c.PublishMinDistance = int(publishMinDistance)
+	}
+	if alias.WatchDebounce != "" {
+		val := getEnvValue(strings.ToUpper(alias.WatchDebounce))
+		watchDebounce, err := strconv.ParseInt(val, 10, 0)
+		if err != nil {
+			return fmt.Errorf("%s: %w", strings.ToUpper(alias.WatchDebounce), err)
+		}
+		c.WatchDebounce = int(watchDebounce)
+	}
+	if alias.WatchPollInterval != "" {
+		val := getEnvValue(strings.ToUpper(alias.WatchPollInterval))
+		watchPollInterval, err := strconv.ParseInt(val, 10, 0)
+		if err != nil {
+			return fmt.Errorf("%s: %w", strings.ToUpper(alias.WatchPollInterval), err)
+		}
+		c.WatchPollInterval = int(watchPollInterval)
+	}
+	if alias.ZoneStaleAfter != "" {
+		val := getEnvValue(strings.ToUpper(alias.ZoneStaleAfter))
+		zoneStaleAfter, err := strconv.ParseInt(val, 10, 0)
+		if err != nil {
+			return fmt.Errorf("%s: %w", strings.ToUpper(alias.ZoneStaleAfter), err)
+		}
+		c.ZoneStaleAfter = int(zoneStaleAfter)
+	}
 	if alias.ForceSync != "" {
 		val := getEnvValue(strings.ToUpper(alias.ForceSync))
 		boolValue, err := strconv.ParseBool(strings.Trim(val, "\""))
 		if err != nil {
-			return err
+			return fmt.Errorf("%s: %w", strings.ToUpper(alias.ForceSync), err)
 		}
 		c.ForceSync = boolValue
 	}
@@ -87,6 +296,68 @@ func (c *Config) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+const (
+	CacheSourceTypeDevices = "devices"
+	CacheSourceTypeItems   = "items"
+)
+
+type CacheSource struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	Required bool   `json:"required"`
+	Type     string `json:"type"`
+}
+
+type LocationSources struct {
+	HTTPPushListen string `json:"http_push_listen"`
+	HTTPPushToken  string `json:"http_push_token"`
+	ReplayPath     string `json:"replay_path"`
+}
+
+// HassEntities enables the Home Assistant entities announced for each device
+// besides its device tracker.
+type HassEntities struct {
+	Address       bool `json:"address"`
+	BatteryLevel  bool `json:"battery_level"`
+	BatteryStatus bool `json:"battery_status"`
+	GPSAccuracy   bool `json:"gps_accuracy"`
+	HomeDistance  bool `json:"home_distance"`
+	LastUpdate    bool `json:"last_update"`
+	Stale         bool `json:"stale"`
+	Zone          bool `json:"zone"`
+}
+
+func (he *HassEntities) UnmarshalJSON(data []byte) error {
+	var values map[string]string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	fields := map[string]*bool{
+		"address":        &he.Address,
+		"battery_level":  &he.BatteryLevel,
+		"battery_status": &he.BatteryStatus,
+		"gps_accuracy":   &he.GPSAccuracy,
+		"home_distance":  &he.HomeDistance,
+		"last_update":    &he.LastUpdate,
+		"stale":          &he.Stale,
+		"zone":           &he.Zone,
+	}
+	for key, value := range values {
+		field, ok := fields[key]
+		if !ok {
+			return fmt.Errorf("unknown Home Assistant entity %q", key)
+		}
+		val := getEnvValue(strings.ToUpper(value))
+		boolValue, err := strconv.ParseBool(strings.Trim(val, "\""))
+		if err != nil {
+			return fmt.Errorf("hass_entities.%s: %w", key, err)
+		}
+		*field = boolValue
+	}
+
+	return nil
+}
+
 type LoggerConfig struct {
 	Directory    string        `json:"directory"`
 	LayoutFormat string        `json:"layout_format"`
@@ -96,13 +367,156 @@ type LoggerConfig struct {
 	Type         string        `json:"type"`
 }
 type Mqtt struct {
-	Broker    string `json:"broker"`
-	ClientID  string `json:"client_id"`
-	HassTopic string `json:"hass_topic"`
-	Password  string `json:"password"`
-	Port      int    `json:"port"`
-	Topic     string `json:"topic"`
-	Username  string `json:"username"`
+	AvailabilityTopic string      `json:"availability_topic"`
+	Broker            string      `json:"broker"`
+	ClientID          string      `json:"client_id"`
+	HassTopic         string      `json:"hass_topic"`
+	Password          string      `json:"password"`
+	Path              string      `json:"path"`
+	Port              int         `json:"port"`
+	Publish           MqttPublish `json:"publish"`
+	Queue             MqttQueue   `json:"queue"`
+	Scheme            string      `json:"scheme"`
+	TLS               MqttTLS     `json:"tls"`
+	Topic             string      `json:"topic"`
+	Username          string      `json:"username"`
+}
+
+// BridgeAvailabilityTopic returns the topic on which the availability of the
+// bridge is published, <topic>/bridge/availability unless configured.
+func (m Mqtt) BridgeAvailabilityTopic() string {
+	if m.AvailabilityTopic != "" {
+		return m.AvailabilityTopic
+	}
+	return fmt.Sprintf("%s/bridge/availability", m.Topic)
+}
+
+const (
+	MqttSchemeTCP = "tcp"
+	MqttSchemeSSL = "ssl"
+	MqttSchemeWS  = "ws"
+	MqttSchemeWSS = "wss"
+)
+
+// MqttPublish sets the QoS and retain flag of each class of messages.
+type MqttPublish struct {
+	Attributes MqttPublishOptions `json:"attributes"`
+	Discovery  MqttPublishOptions `json:"discovery"`
+	Events     MqttPublishOptions `json:"events"`
+	State      MqttPublishOptions `json:"state"`
+}
+
+type MqttPublishOptions struct {
+	QoS    int  `json:"qos"`
+	Retain bool `json:"retain"`
+}
+
+func (mpo *MqttPublishOptions) UnmarshalJSON(data []byte) error {
+	type AliasMqttPublishOptions MqttPublishOptions
+	alias := &struct {
+		QoS    string `json:"qos"`
+		Retain string `json:"retain"`
+		*AliasMqttPublishOptions
+	}{
+		AliasMqttPublishOptions: (*AliasMqttPublishOptions)(mpo),
+	}
+
+	if err := json.Unmarshal(data, &alias); err != nil {
+		return err
+	}
+
+	if alias.QoS != "" {
+		val := getEnvValue(strings.ToUpper(alias.QoS))
+		qos, err := strconv.ParseInt(val, 10, 0)
+		if err != nil {
+			return fmt.Errorf("%s: %w", strings.ToUpper(alias.QoS), err)
+		}
+		mpo.QoS = int(qos)
+	}
+	if alias.Retain != "" {
+		val := getEnvValue(strings.ToUpper(alias.Retain))
+		boolValue, err := strconv.ParseBool(strings.Trim(val, "\""))
+		if err != nil {
+			return fmt.Errorf("%s: %w", strings.ToUpper(alias.Retain), err)
+		}
+		mpo.Retain = boolValue
+	}
+
+	return nil
+}
+
+type MqttQueue struct {
+	MaxAge      int    `json:"max_age"`
+	MaxMessages int    `json:"max_messages"`
+	Path        string `json:"path"`
+}
+
+func (mq *MqttQueue) UnmarshalJSON(data []byte) error {
+	type AliasMqttQueue MqttQueue
+	alias := &struct {
+		MaxAge      string `json:"max_age"`
+		MaxMessages string `json:"max_messages"`
+		*AliasMqttQueue
+	}{
+		AliasMqttQueue: (*AliasMqttQueue)(mq),
+	}
+
+	if err := json.Unmarshal(data, &alias); err != nil {
+		return err
+	}
+
+	if alias.MaxAge != "" {
+		val := getEnvValue(strings.ToUpper(alias.MaxAge))
+		maxAge, err := strconv.ParseInt(val, 10, 0)
+		if err != nil {
+			return fmt.Errorf("%s: %w", strings.ToUpper(alias.MaxAge), err)
+		}
+		mq.MaxAge = int(maxAge)
+	}
+	if alias.MaxMessages != "" {
+		val := getEnvValue(strings.ToUpper(alias.MaxMessages))
+		maxMessages, err := strconv.ParseInt(val, 10, 0)
+		if err != nil {
+			return fmt.Errorf("%s: %w", strings.ToUpper(alias.MaxMessages), err)
+		}
+		mq.MaxMessages = int(maxMessages)
+	}
+
+	return nil
+}
+
+type MqttTLS struct {
+	CAFile     string `json:"ca_file"`
+	CertFile   string `json:"cert_file"`
+	Insecure   bool   `json:"insecure"`
+	KeyFile    string `json:"key_file"`
+	MinVersion string `json:"min_version"`
+	ServerName string `json:"server_name"`
+}
+
+func (mt *MqttTLS) UnmarshalJSON(data []byte) error {
+	type AliasMqttTLS MqttTLS
+	alias := &struct {
+		Insecure string `json:"insecure"`
+		*AliasMqttTLS
+	}{
+		AliasMqttTLS: (*AliasMqttTLS)(mt),
+	}
+
+	if err := json.Unmarshal(data, &alias); err != nil {
+		return err
+	}
+
+	if alias.Insecure != "" {
+		val := getEnvValue(strings.ToUpper(alias.Insecure))
+		boolValue, err := strconv.ParseBool(strings.Trim(val, "\""))
+		if err != nil {
+			return fmt.Errorf("%s: %w", strings.ToUpper(alias.Insecure), err)
+		}
+		mt.Insecure = boolValue
+	}
+
+	return nil
 }
 
 func (m *Mqtt) UnmarshalJSON(data []byte) error {
@@ -122,7 +536,7 @@ func (m *Mqtt) UnmarshalJSON(data []byte) error {
 		val := getEnvValue(strings.ToUpper(alias.Port))
 		port, err := strconv.ParseInt(val, 10, 0)
 		if err != nil {
-			return err
+			return fmt.Errorf("%s: %w", strings.ToUpper(alias.Port), err)
 		}
 		m.Port = int(port)
 	}
@@ -148,19 +562,29 @@ func SetupConfig(_envPath string) error {
 func newConfig(config *Config) error {
 	currentDirectory, err := os.Getwd()
 	if err != nil {
-		panic(err.Error())
+		return err
 	}
 	_dotEnvPath := filepath.Join(currentDirectory, envPath)
 	if err := godotenv.Load(_dotEnvPath); err != nil {
-		panic(err.Error())
+		return fmt.Errorf("error loading the env file %s: %w", _dotEnvPath, err)
 	}
 
 	file, err := os.ReadFile("config.json")
 	if err != nil {
-		panic(err.Error())
+		return fmt.Errorf("error reading config.json: %w", err)
 	}
+	var raw any
+	if err := json.Unmarshal(file, &raw); err != nil {
+		return fmt.Errorf("error parsing config.json: %w", err)
+	}
+	config.unresolved = unresolvedPlaceholders("", raw)
 	if err := json.Unmarshal(file, &config); err != nil {
-		panic(err.Error())
+		// Placeholders left unresolved cannot be parsed as numbers or
+		// booleans, they are the error to report.
+		if len(config.unresolved) > 0 {
+			return &ValidationError{Problems: config.unresolved}
+		}
+		return fmt.Errorf("error parsing config.json: %w", err)
 	}
 
 	replaceWithEnv(config)
@@ -168,13 +592,28 @@ func newConfig(config *Config) error {
 	return nil
 }
 
+// LoadConfig loads and validates the configuration, reporting every problem
+// found at once.
+func LoadConfig() (Config, error) {
+	config := &Config{}
+	if err := newConfig(config); err != nil {
+		return Config{}, err
+	}
+	if err := config.Validate(); err != nil {
+		return Config{}, err
+	}
+	globalConfig = config
+	return *config, nil
+}
+
 func GetConfig() Config {
 	const names = "__config.go__ : GetConfig"
 	if globalConfig == nil {
-		globalConfig = &Config{}
-		if err := newConfig(globalConfig); err != nil {
+		config, err := LoadConfig()
+		if err != nil {
 			panic(fmt.Sprintf("%s | %s", names, err))
 		}
+		globalConfig = &config
 	}
 	return *globalConfig
 }
@@ -199,24 +638,32 @@ func replaceField(v reflect.Value) {
 	}
 }
 
+// getEnvValue returns the value of a placeholder, the key itself when it is
+// neither set nor has a default.
 func getEnvValue(key string) string {
-	if value, exists := os.LookupEnv(key); exists {
+	if value, ok := lookupEnvValue(key); ok {
 		return value
 	}
+	return key
+}
+
+func lookupEnvValue(key string) (string, bool) {
+	if value, exists := os.LookupEnv(key); exists {
+		return value, true
+	}
 	if value, ok := ENV_DEFAULT[key]; ok {
 		switch val := value.(type) {
 		case string:
-			return val
+			return val, true
 		case int:
-			return strconv.Itoa(val)
+			return strconv.Itoa(val), true
 		case float64:
-			return strconv.FormatFloat(val, 'f', -1, 64)
+			return strconv.FormatFloat(val, 'f', -1, 64), true
 		default:
-			return fmt.Sprintf("%v", value)
+			return fmt.Sprintf("%v", value), true
 		}
 	}
-
-	return key
+	return "", false
 }
 
 func replaceValue(v reflect.Value) {
@@ -0,0 +1,218 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ValidationError lists every problem found in the configuration.
+type ValidationError struct {
+	Problems []string
+}
+
+func (ve *ValidationError) Error() string {
+	return fmt.Sprintf("invalid configuration, %d problem(s):\n  - %s", len(ve.Problems), strings.Join(ve.Problems, "\n  - "))
+}
+
+// placeholderPattern matches the values of config.json written as environment
+// variable names, upper case letters, digits and underscores.
+var placeholderPattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// unresolvedPlaceholders returns a problem for every placeholder of the raw
+// config.json that is neither set in the environment nor has an entry in
+// ENV_DEFAULT, such as a misspelled one, which would otherwise be taken as a
+// literal value.
+func unresolvedPlaceholders(path string, raw any) []string {
+	var problems []string
+	switch value := raw.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			keyPath := key
+			if path != "" {
+				keyPath = path + "." + key
+			}
+			problems = append(problems, unresolvedPlaceholders(keyPath, value[key])...)
+		}
+	case []any:
+		for i, item := range value {
+			problems = append(problems, unresolvedPlaceholders(fmt.Sprintf("%s[%d]", path, i), item)...)
+		}
+	case string:
+		if !placeholderPattern.MatchString(value) {
+			break
+		}
+		if _, ok := lookupEnvValue(value); !ok {
+			problems = append(problems, fmt.Sprintf("%s: %s is not set in the environment or the env file and has no default", path, value))
+		}
+	}
+	return problems
+}
+
+// Validate checks the values of the configuration and returns a
+// ValidationError listing all the problems found.
+func (c Config) Validate() error {
+	problems := append([]string{}, c.unresolved...)
+	add := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	oneOf := func(key, value string, values ...string) {
+		for _, v := range values {
+			if value == v {
+				return
+			}
+		}
+		add("%s: %q is not one of %s", key, value, strings.Join(values, ", "))
+	}
+	atLeast := func(key string, value, min int) {
+		if value < min {
+			add("%s: %d must be at least %d", key, value, min)
+		}
+	}
+
+	oneOf("environment", c.Environment, "development", "production")
+	oneOf("log_level", c.LogLevel, "debug", "info", "warn", "error", "fatal")
+	oneOf("scan_mode", c.ScanMode, ScanModeTicker, ScanModeWatch, ScanModePoll)
+	oneOf("device_id_strategy", c.DeviceIDStrategy, DeviceIDStrategyStable, DeviceIDStrategyName)
+	if _, err := time.LoadLocation(c.TZ); err != nil {
+		add("tz: unknown time zone %q", c.TZ)
+	}
+
+	// Every mode runs a scan at least every scan_timer seconds.
+	atLeast("scan_timer", c.ScanTimer, 1)
+	atLeast("scan_concurrency", c.ScanConcurrency, 1)
+	atLeast("scan_timeout", c.ScanTimeout, 0)
+	atLeast("shutdown_timeout", c.ShutdownTimeout, 1)
+	atLeast("watch_debounce", c.WatchDebounce, 0)
+	atLeast("watch_poll_interval", c.WatchPollInterval, 1)
+	atLeast("cleanup_grace_period", c.CleanupGracePeriod, 0)
+	atLeast("device_availability_timeout", c.DeviceAvailabilityTimeout, 0)
+	atLeast("zone_stale_after", c.ZoneStaleAfter, 0)
+	atLeast("known_locations_default_tolerance", c.KnownLocationsDefaultTolerance, 0)
+	atLeast("known_locations_dwell_time", c.KnownLocationsDwellTime, 0)
+	atLeast("known_locations_exit_margin", c.KnownLocationsExitMargin, 0)
+	atLeast("publish_battery_change", c.PublishBatteryChange, 0)
+	atLeast("publish_heartbeat", c.PublishHeartbeat, 0)
+	atLeast("publish_min_distance", c.PublishMinDistance, 0)
+
+	c.Mqtt.validate(add, oneOf, atLeast)
+
+	for i, source := range c.CacheSources {
+		key := fmt.Sprintf("cache_sources[%d]", i)
+		filePath, err := source.FilePath()
+		if err != nil {
+			add("%s: %s", key, err)
+			continue
+		}
+		if source.Required {
+			if err := checkReadable(filePath); err != nil {
+				add("%s.path: %s", key, err)
+			}
+		}
+	}
+	if c.LocationSources.HTTPPushListen != "" {
+		if _, _, err := net.SplitHostPort(c.LocationSources.HTTPPushListen); err != nil {
+			add("location_sources.http_push_listen: %s", err)
+		}
+	}
+
+	for key, path := range map[string]string{
+		"cache_key_path":               c.CacheKeyPath,
+		"location_sources.replay_path": c.LocationSources.ReplayPath,
+		"mqtt.tls.ca_file":             c.Mqtt.TLS.CAFile,
+		"mqtt.tls.cert_file":           c.Mqtt.TLS.CertFile,
+		"mqtt.tls.key_file":            c.Mqtt.TLS.KeyFile,
+	} {
+		if path == "" {
+			continue
+		}
+		if err := checkReadable(path); err != nil {
+			add("%s: %s", key, err)
+		}
+	}
+	for key, path := range map[string]string{
+		"device_state_path":    c.DeviceStatePath,
+		"ignored_devices_path": c.IgnoredDevicesPath,
+		"mqtt.queue.path":      c.Mqtt.Queue.Path,
+	} {
+		if path == "" {
+			continue
+		}
+		if info, err := os.Stat(filepath.Dir(path)); err != nil || !info.IsDir() {
+			add("%s: directory %s does not exist", key, filepath.Dir(path))
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	// Paths are checked from maps, sort for a stable report.
+	sort.Strings(problems)
+	return &ValidationError{Problems: problems}
+}
+
+func (m Mqtt) validate(add func(string, ...any), oneOf func(string, string, ...string), atLeast func(string, int, int)) {
+	if m.Broker == "" {
+		add("mqtt.broker: must be set")
+	}
+	if m.Port < 1 || m.Port > 65535 {
+		add("mqtt.port: %d is not a valid port", m.Port)
+	}
+	if m.Scheme != "" {
+		oneOf("mqtt.scheme", strings.ToLower(m.Scheme), MqttSchemeTCP, MqttSchemeSSL, MqttSchemeWS, MqttSchemeWSS)
+	}
+	for key, topic := range map[string]string{
+		"mqtt.availability_topic": m.AvailabilityTopic,
+		"mqtt.hass_topic":         m.HassTopic,
+		"mqtt.topic":              m.Topic,
+	} {
+		if topic == "" && key != "mqtt.availability_topic" {
+			add("%s: must be set", key)
+		}
+		if strings.ContainsAny(topic, "+#") {
+			add("%s: %q must not contain the wildcards + or #", key, topic)
+		}
+	}
+	for key, options := range map[string]MqttPublishOptions{
+		"mqtt.publish.attributes": m.Publish.Attributes,
+		"mqtt.publish.discovery":  m.Publish.Discovery,
+		"mqtt.publish.events":     m.Publish.Events,
+		"mqtt.publish.state":      m.Publish.State,
+	} {
+		if options.QoS < 0 || options.QoS > 2 {
+			add("%s.qos: %d is not 0, 1 or 2", key, options.QoS)
+		}
+	}
+	atLeast("mqtt.queue.max_age", m.Queue.MaxAge, 0)
+	atLeast("mqtt.queue.max_messages", m.Queue.MaxMessages, 0)
+	if m.TLS.MinVersion != "" {
+		oneOf("mqtt.tls.min_version", m.TLS.MinVersion, "1.0", "1.1", "1.2", "1.3")
+	}
+	if (m.TLS.CertFile == "") != (m.TLS.KeyFile == "") {
+		add("mqtt.tls: cert_file and key_file must be set together")
+	}
+}
+
+// checkReadable returns an error when path does not exist or cannot be read.
+func checkReadable(path string) error {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%s does not exist", path)
+	}
+	if err != nil {
+		return fmt.Errorf("%s cannot be read: %w", path, errors.Unwrap(err))
+	}
+	return file.Close()
+}
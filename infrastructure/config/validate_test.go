@@ -0,0 +1,30 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnresolvedPlaceholders(t *testing.T) {
+	t.Setenv("MQTT_BROKER", "broker.local")
+	raw := map[string]any{
+		"client_id": "bridge",
+		"log_level": "LOG_LEVEL",
+		"mqtt": map[string]any{
+			"broker":   "MQTT_BROKER",
+			"port":     "MQTT_PORT",
+			"username": "MQTT_USERNAM",
+		},
+		"cache_sources": []any{
+			map[string]any{"path": "~/Library/Caches", "type": "FINDMY_CACHE_TYPE"},
+		},
+		"scan_timer": 5.0,
+	}
+	want := []string{
+		"cache_sources[0].type: FINDMY_CACHE_TYPE is not set in the environment or the env file and has no default",
+		"mqtt.username: MQTT_USERNAM is not set in the environment or the env file and has no default",
+	}
+	if got := unresolvedPlaceholders("", raw); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,148 @@
+package dataproviders
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"howett.net/plist"
+)
+
+// Recent macOS versions write the fmipcore cache files as a property list
+// holding the nonce, the authentication tag and the ciphertext of an
+// AES-256-GCM encrypted property list. The key is stored in the keychain and
+// has to be extracted once by the user.
+
+const cacheKeySize = 32
+
+var errCacheKeyMissing = errors.New("cache file is encrypted but no key is configured, set cache_key or cache_key_path")
+
+// isPlist reports whether data is a binary or XML property list.
+func isPlist(data []byte) bool {
+	if bytes.HasPrefix(data, []byte("bplist00")) {
+		return true
+	}
+	trimmed := bytes.TrimSpace(data)
+	return bytes.HasPrefix(trimmed, []byte("<?xml")) || bytes.HasPrefix(trimmed, []byte("<plist"))
+}
+
+// parseCacheKey decodes a hex or base64 encoded AES-256 key.
+func parseCacheKey(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	if key, err := hex.DecodeString(value); err == nil && len(key) == cacheKeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(value); err == nil && len(key) == cacheKeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("cache key must be %d bytes encoded in hex or base64", cacheKeySize)
+}
+
+// loadCacheKey returns the key given inline, or read from keyPath. It returns
+// nil when neither is configured.
+func loadCacheKey(key, keyPath string) ([]byte, error) {
+	if key != "" {
+		return parseCacheKey(key)
+	}
+	if keyPath == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading cache key file: %w", err)
+	}
+	if len(data) == cacheKeySize {
+		return data, nil
+	}
+	return parseCacheKey(string(data))
+}
+
+// decryptCacheData decrypts an encrypted cache container and returns the inner
+// payload.
+func decryptCacheData(data, key []byte) ([]byte, error) {
+	if key == nil {
+		return nil, errCacheKeyMissing
+	}
+	var container [][]byte
+	if _, err := plist.Unmarshal(data, &container); err != nil {
+		return nil, fmt.Errorf("unexpected encrypted container format: %w", err)
+	}
+	if len(container) < 3 {
+		return nil, fmt.Errorf("unexpected encrypted container format: %d elements, expected nonce, tag and ciphertext", len(container))
+	}
+	nonce, tag, ciphertext := container[0], container[1], container[2]
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(nonce))
+	if err != nil {
+		return nil, err
+	}
+	if len(tag) != gcm.Overhead() {
+		return nil, fmt.Errorf("unexpected authentication tag size %d", len(tag))
+	}
+	sealed := make([]byte, 0, len(ciphertext)+len(tag))
+	sealed = append(sealed, ciphertext...)
+	sealed = append(sealed, tag...)
+	payload, err := gcm.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting cache file, check the cache key: %w", err)
+	}
+	return payload, nil
+}
+
+// decodeCachePayload converts a decrypted payload to the JSON array used by
+// unencrypted cache files.
+func decodeCachePayload(payload []byte) ([]byte, error) {
+	if !isPlist(payload) {
+		return payload, nil
+	}
+	var value any
+	if _, err := plist.Unmarshal(payload, &value); err != nil {
+		return nil, fmt.Errorf("error decoding decrypted cache file: %w", err)
+	}
+	if dict, ok := value.(map[string]any); ok {
+		var records []any
+		for _, v := range dict {
+			if array, ok := v.([]any); ok {
+				if records != nil {
+					return nil, errors.New("error decoding decrypted cache file: ambiguous record list")
+				}
+				records = array
+			}
+		}
+		if records == nil {
+			return nil, errors.New("error decoding decrypted cache file: no record list")
+		}
+		value = records
+	}
+	return json.Marshal(normalizePlistValue(value))
+}
+
+// normalizePlistValue converts property list dates to the millisecond
+// timestamps used by unencrypted cache files.
+func normalizePlistValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			v[key] = normalizePlistValue(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = normalizePlistValue(item)
+		}
+	case time.Time:
+		return v.UnixMilli()
+	}
+	return value
+}
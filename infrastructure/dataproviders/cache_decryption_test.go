@@ -0,0 +1,173 @@
+package dataproviders
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testdata/encrypted_devices.data holds one device, encrypted with the key in
+// testdata/cache_key.txt.
+const (
+	testCacheFile    = "testdata/encrypted_devices.data"
+	testCacheKey     = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	testCacheKeyFile = "testdata/cache_key.txt"
+)
+
+func readTestCacheFile(t *testing.T) []byte {
+	t.Helper()
+	data, err := os.ReadFile(testCacheFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func testKey(t *testing.T) []byte {
+	t.Helper()
+	key, err := hex.DecodeString(testCacheKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestDecryptCacheDataRoundTrip(t *testing.T) {
+	data := readTestCacheFile(t)
+	if !isPlist(data) {
+		t.Fatal("fixture is not detected as a property list")
+	}
+	key, err := loadCacheKey("", testCacheKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := decryptCacheData(data, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decodeCachePayload(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	findMyDevices, err := unmarshalData(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(findMyDevices) != 1 {
+		t.Fatalf("got %d devices, want 1", len(findMyDevices))
+	}
+	device := findMyDevices[0]
+	if device.Name != "Test AirTag" {
+		t.Errorf("name: got %q, want %q", device.Name, "Test AirTag")
+	}
+	if device.Identifier != "A1B2C3D4-0000-4000-8000-000000000001" {
+		t.Errorf("identifier: got %q", device.Identifier)
+	}
+	if device.Location.Latitude != 48.8584 || device.Location.Longitude != 2.2945 {
+		t.Errorf("location: got %v, %v", device.Location.Latitude, device.Location.Longitude)
+	}
+	if device.Location.HorizontalAccuracy != 12.5 {
+		t.Errorf("horizontal accuracy: got %v, want 12.5", device.Location.HorizontalAccuracy)
+	}
+	want := time.Date(2024, 5, 2, 8, 30, 0, 0, time.UTC).UnixMilli()
+	if device.Location.TimeStamp != want {
+		t.Errorf("timestamp: got %d, want %d", device.Location.TimeStamp, want)
+	}
+}
+
+func TestDecryptCacheDataWrongKey(t *testing.T) {
+	key := bytes.Repeat([]byte{0xff}, cacheKeySize)
+	_, err := decryptCacheData(readTestCacheFile(t), key)
+	if err == nil || !strings.Contains(err.Error(), "check the cache key") {
+		t.Fatalf("got %v, want a decryption error", err)
+	}
+}
+
+func TestDecryptCacheDataMissingKey(t *testing.T) {
+	_, err := decryptCacheData(readTestCacheFile(t), nil)
+	if !errors.Is(err, errCacheKeyMissing) {
+		t.Fatalf("got %v, want %v", err, errCacheKeyMissing)
+	}
+}
+
+func TestParseCacheKey(t *testing.T) {
+	key := testKey(t)
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{name: "hex", value: testCacheKey},
+		{name: "hex with whitespace", value: "  " + testCacheKey + "\n"},
+		{name: "base64", value: base64.StdEncoding.EncodeToString(key)},
+		{name: "too short", value: testCacheKey[:32], wantErr: true},
+		{name: "invalid", value: "not a key", wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCacheKey(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, key) {
+				t.Errorf("got %x, want %x", got, key)
+			}
+		})
+	}
+}
+
+func TestLoadCacheKey(t *testing.T) {
+	key := testKey(t)
+	rawPath := filepath.Join(t.TempDir(), "cache.key")
+	if err := os.WriteFile(rawPath, key, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		key     string
+		keyPath string
+		want    []byte
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "inline", key: testCacheKey, want: key},
+		{name: "inline wins over the file", key: testCacheKey, keyPath: "testdata/missing.key", want: key},
+		{name: "raw file", keyPath: rawPath, want: key},
+		{name: "hex file", keyPath: testCacheKeyFile, want: key},
+		{name: "invalid inline", key: "not a key", wantErr: true},
+		{name: "missing file", keyPath: "testdata/missing.key", wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadCacheKey(tt.key, tt.keyPath)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("got %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package dataproviders
+
+import (
+	"apple-findmy-to-mqtt/core/interfaces"
+	"apple-findmy-to-mqtt/infrastructure/config"
+	"apple-findmy-to-mqtt/infrastructure/logging"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+type CacheWatcherParams struct {
+	fx.In
+	Config          config.Config
+	FileCacheReader interfaces.IFileCacheReader
+	Logger          logging.Logger
+}
+
+type cacheWatcher struct {
+	config          config.Config
+	fileCacheReader interfaces.IFileCacheReader
+	logger          logging.Logger
+}
+
+func NewCacheWatcher(cwp CacheWatcherParams) interfaces.ICacheWatcher {
+	return &cacheWatcher{
+		config:          cwp.Config,
+		fileCacheReader: cwp.FileCacheReader,
+		logger:          cwp.Logger,
+	}
+}
+
+func (cw *cacheWatcher) Watch(ctx context.Context, onChange func()) error {
+	const names = "__cache_watcher.go__: Watch"
+	filePaths, err := cw.fileCacheReader.GetCacheFilePaths()
+	if err != nil {
+		return fmt.Errorf("%s | %w", names, err)
+	}
+	watcher := newFileWatcher(
+		cw.logger,
+		filePaths,
+		time.Duration(cw.config.WatchDebounce)*time.Millisecond,
+		time.Duration(cw.config.WatchPollInterval)*time.Second,
+		cw.config.ScanMode == config.ScanModePoll,
+	)
+	cw.logger.Info(fmt.Sprintf("%s | Watching %s", names, strings.Join(filePaths, ", ")))
+	return watcher.Watch(ctx, func(changed []string) {
+		cw.logger.Info(fmt.Sprintf("%s | Cache changed: %s", names, strings.Join(changed, ", ")))
+		onChange()
+	})
+}
@@ -0,0 +1,61 @@
+package dataproviders
+
+import (
+	"apple-findmy-to-mqtt/core/entities"
+	"apple-findmy-to-mqtt/core/interfaces"
+	"apple-findmy-to-mqtt/infrastructure/config"
+	"apple-findmy-to-mqtt/infrastructure/logging"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"go.uber.org/fx"
+)
+
+type DeviceIDMigrationFileParams struct {
+	fx.In
+	Config config.Config
+	Logger logging.Logger
+}
+
+type deviceIDMigrationFile struct {
+	logger     logging.Logger
+	migrations entities.DeviceIDMigrationMap
+}
+
+func NewDeviceIDMigrationFile(dimfp DeviceIDMigrationFileParams) interfaces.IDeviceIDMigrationFile {
+	const names = "__device_id_migration_file.go__: NewDeviceIDMigrationFile"
+	dimf := &deviceIDMigrationFile{
+		logger: dimfp.Logger,
+	}
+	migrations, err := dimf.LoadMigrationsFromFile(dimfp.Config.DeviceIDMigrationsPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		dimf.logger.Error(fmt.Sprintf("%s | %s", names, err.Error()))
+	}
+	dimf.migrations = migrations
+	return dimf
+}
+
+func (dimf *deviceIDMigrationFile) LoadMigrationsFromFile(filePath string) (entities.DeviceIDMigrationMap, error) {
+	migrations := make(entities.DeviceIDMigrationMap)
+	if filePath == "" {
+		return migrations, nil
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return migrations, err
+	}
+
+	if err := json.Unmarshal(data, &migrations); err != nil {
+		return make(entities.DeviceIDMigrationMap), fmt.Errorf("%s: %w", filePath, err)
+	}
+
+	return migrations, nil
+}
+
+func (dimf *deviceIDMigrationFile) GetAllMigrations() entities.DeviceIDMigrationMap {
+	return dimf.migrations
+}
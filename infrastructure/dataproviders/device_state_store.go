@@ -0,0 +1,168 @@
+package dataproviders
+
+import (
+	"apple-findmy-to-mqtt/core/entities"
+	"apple-findmy-to-mqtt/core/interfaces"
+	"apple-findmy-to-mqtt/infrastructure/config"
+	"context"
+	"fmt"
+	"sync"
+
+	"go.uber.org/fx"
+)
+
+type DeviceStateStoreParams struct {
+	fx.In
+	Config    config.Config
+	Lifecycle fx.Lifecycle
+}
+
+// memoryDeviceStateStore keeps the states in memory only, so that they are
+// lost on restart.
+type memoryDeviceStateStore struct {
+	dirty  bool
+	mu     sync.RWMutex
+	states entities.DeviceStateMap
+}
+
+// fileDeviceStateStore keeps the states in memory and writes a snapshot of
+// them to a JSON file when flushed.
+type fileDeviceStateStore struct {
+	*memoryDeviceStateStore
+	flushMu sync.Mutex
+	path    string
+}
+
+// NewDeviceStateStore returns the store persisted at device_state_path, or an
+// in-memory store when the path is empty. The states are flushed when the
+// application stops.
+func NewDeviceStateStore(dssp DeviceStateStoreParams) (interfaces.IDeviceStateStore, error) {
+	var store interfaces.IDeviceStateStore = newMemoryDeviceStateStore()
+	if path := dssp.Config.DeviceStatePath; path != "" {
+		fileStore, err := newFileDeviceStateStore(path)
+		if err != nil {
+			return nil, err
+		}
+		store = fileStore
+	}
+	dssp.Lifecycle.Append(fx.Hook{
+		OnStop: func(context.Context) error {
+			return store.Flush()
+		},
+	})
+	return store, nil
+}
+
+func newMemoryDeviceStateStore() *memoryDeviceStateStore {
+	return &memoryDeviceStateStore{
+		states: make(entities.DeviceStateMap),
+	}
+}
+
+func (mdss *memoryDeviceStateStore) Delete(id string) {
+	mdss.mu.Lock()
+	defer mdss.mu.Unlock()
+
+	if _, exists := mdss.states[id]; exists {
+		delete(mdss.states, id)
+		mdss.dirty = true
+	}
+}
+
+func (mdss *memoryDeviceStateStore) DeleteAll() {
+	mdss.mu.Lock()
+	defer mdss.mu.Unlock()
+
+	if len(mdss.states) > 0 {
+		mdss.states = make(entities.DeviceStateMap)
+		mdss.dirty = true
+	}
+}
+
+func (mdss *memoryDeviceStateStore) Flush() error {
+	return nil
+}
+
+func (mdss *memoryDeviceStateStore) Get(id string) (entities.DeviceState, bool) {
+	mdss.mu.RLock()
+	defer mdss.mu.RUnlock()
+
+	state, exists := mdss.states[id]
+	return state, exists
+}
+
+func (mdss *memoryDeviceStateStore) GetAll() entities.DeviceStateMap {
+	mdss.mu.RLock()
+	defer mdss.mu.RUnlock()
+
+	states := make(entities.DeviceStateMap, len(mdss.states))
+	for id, state := range mdss.states {
+		states[id] = state
+	}
+	return states
+}
+
+func (mdss *memoryDeviceStateStore) Update(id string, update func(state *entities.DeviceState)) {
+	mdss.mu.Lock()
+	defer mdss.mu.Unlock()
+
+	state, exists := mdss.states[id]
+	if !exists {
+		state.ID = id
+	}
+	update(&state)
+	mdss.states[id] = state
+	mdss.dirty = true
+}
+
+// snapshot returns a copy of the states when they changed since the last
+// snapshot.
+func (mdss *memoryDeviceStateStore) snapshot() (entities.DeviceStateMap, bool) {
+	mdss.mu.Lock()
+	defer mdss.mu.Unlock()
+
+	if !mdss.dirty {
+		return nil, false
+	}
+	mdss.dirty = false
+	states := make(entities.DeviceStateMap, len(mdss.states))
+	for id, state := range mdss.states {
+		states[id] = state
+	}
+	return states, true
+}
+
+// newFileDeviceStateStore loads the states saved at path, none when the file
+// does not exist yet.
+func newFileDeviceStateStore(path string) (*fileDeviceStateStore, error) {
+	fdss := &fileDeviceStateStore{
+		memoryDeviceStateStore: newMemoryDeviceStateStore(),
+		path:                   path,
+	}
+	if err := readJSONFile(path, &fdss.states); err != nil {
+		return nil, fmt.Errorf("error reading device state: %w", err)
+	}
+	if fdss.states == nil {
+		fdss.states = make(entities.DeviceStateMap)
+	}
+	return fdss, nil
+}
+
+// Flush writes the states to the file. Nothing is written when the states did
+// not change.
+func (fdss *fileDeviceStateStore) Flush() error {
+	fdss.flushMu.Lock()
+	defer fdss.flushMu.Unlock()
+
+	states, changed := fdss.snapshot()
+	if !changed {
+		return nil
+	}
+	if err := writeJSONAtomic(fdss.path, states); err != nil {
+		fdss.mu.Lock()
+		fdss.dirty = true
+		fdss.mu.Unlock()
+		return err
+	}
+	return nil
+}
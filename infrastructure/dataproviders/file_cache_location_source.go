@@ -0,0 +1,33 @@
+package dataproviders
+
+import (
+	"apple-findmy-to-mqtt/core/entities"
+	"apple-findmy-to-mqtt/core/interfaces"
+
+	"go.uber.org/fx"
+)
+
+type FileCacheLocationSourceParams struct {
+	fx.In
+	FileCacheReader interfaces.IFileCacheReader
+}
+
+type fileCacheLocationSource struct {
+	fileCacheReader interfaces.IFileCacheReader
+}
+
+func NewFileCacheLocationSource(fclsp FileCacheLocationSourceParams) interfaces.ILocationSource {
+	return &fileCacheLocationSource{
+		fileCacheReader: fclsp.FileCacheReader,
+	}
+}
+
+func (fcls *fileCacheLocationSource) Name() string {
+	return "findmy_cache"
+}
+
+// ReadDevices returns the devices of every configured cache source, each
+// tagged with the name of the cache source it was read from.
+func (fcls *fileCacheLocationSource) ReadDevices() ([]entities.Device, error) {
+	return fcls.fileCacheReader.ReadDevicesData()
+}
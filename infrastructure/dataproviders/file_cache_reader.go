@@ -5,12 +5,17 @@ import (
 	"apple-findmy-to-mqtt/core/interfaces"
 	"apple-findmy-to-mqtt/infrastructure/config"
 	"apple-findmy-to-mqtt/infrastructure/logging"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"math"
 	"os"
 	"os/user"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -18,23 +23,57 @@ import (
 )
 
 type FindMyData struct {
-	Address       FindMyDataAddress  `json:"address"`
-	BatteryStatus string             `json:"batteryStatus"`
-	DeviceClass   string             `json:"deviceClass"`
-	Location      FindMyDataLocation `json:"location"`
-	ModelName     string             `json:"modelDisplayName"`
-	Name          string             `json:"name"`
+	Address              FindMyDataAddress        `json:"address"`
+	BaUUID               string                   `json:"baUUID"`
+	BatteryLevel         *float64                 `json:"batteryLevel"`
+	BatteryStatus        FindMyDataString         `json:"batteryStatus"`
+	CrowdSourcedLocation *FindMyDataLocation      `json:"crowdSourcedLocation"`
+	DeviceClass          string                   `json:"deviceClass"`
+	Identifier           string                   `json:"identifier"`
+	Location             FindMyDataLocation       `json:"location"`
+	LowPowerMode         bool                     `json:"lowPowerMode"`
+	ModelName            string                   `json:"modelDisplayName"`
+	Name                 string                   `json:"name"`
+	PartInfo             json.RawMessage          `json:"partInfo"`
+	ProductType          FindMyDataProductType    `json:"productType"`
+	Role                 FindMyDataRole           `json:"role"`
+	SafeLocations        []FindMyDataSafeLocation `json:"safeLocations"`
+	SerialNumber         string                   `json:"serialNumber"`
+}
+
+// StableIdentifier returns the first Apple identifier available for the
+// record, in order of stability: item identifier, serial number, then
+// Bluetooth UUID.
+func (fmd FindMyData) StableIdentifier() string {
+	for _, identifier := range []string{fmd.Identifier, fmd.SerialNumber, fmd.BaUUID} {
+		if identifier != "" {
+			return identifier
+		}
+	}
+	return ""
 }
 
 type FindMyDataAddress struct {
-	Country     string `json:"country"`
-	CountryCode string `json:"countryCode"`
-	Locality    string `json:"locality"`
-	FullAddress string `json:"mapItemFullAddress"`
+	AdministrativeArea    string   `json:"administrativeArea"`
+	Country               string   `json:"country"`
+	CountryCode           string   `json:"countryCode"`
+	FormattedAddressLines []string `json:"formattedAddressLines"`
+	FullAddress           string   `json:"mapItemFullAddress"`
+	FullThoroughfare      string   `json:"fullThroroughfare"`
+	Locality              string   `json:"locality"`
+	PostalCode            string   `json:"postalCode"`
+	StateCode             string   `json:"stateCode"`
+	StreetAddress         string   `json:"streetAddress"`
+	StreetName            string   `json:"streetName"`
+	SubAdministrativeArea string   `json:"subAdministrativeArea"`
 }
 
 type FindMyDataLocation struct {
+	Altitude           float64 `json:"altitude"`
+	FloorLevel         float64 `json:"floorLevel"`
 	HorizontalAccuracy float64 `json:"horizontalAccuracy"`
+	IsInaccurate       bool    `json:"isInaccurate"`
+	IsOld              bool    `json:"isOld"`
 	Latitude           float64 `json:"latitude"`
 	Longitude          float64 `json:"longitude"`
 	PositionType       string  `json:"positionType"`
@@ -42,15 +81,71 @@ type FindMyDataLocation struct {
 	VerticalAccuracy   float64 `json:"verticalAccuracy"`
 }
 
-type FindMyDevice struct {
-	FindMyData
+// FindMyDataProductType is an object in Items.data and a plain string in some
+// Devices.data records.
+type FindMyDataProductType struct {
+	ProductInformation struct {
+		ManufacturerName string `json:"manufacturerName"`
+		ModelName        string `json:"modelName"`
+	} `json:"productInformation"`
+	Type string `json:"type"`
 }
 
-type deviceUpdateInfo struct {
-	LastUpdateTime time.Time
+func (fmdpt *FindMyDataProductType) UnmarshalJSON(data []byte) error {
+	var productType string
+	if err := json.Unmarshal(data, &productType); err == nil {
+		fmdpt.Type = productType
+		return nil
+	}
+	type AliasProductType FindMyDataProductType
+	return json.Unmarshal(data, (*AliasProductType)(fmdpt))
 }
 
-var deviceUpdates = make(map[string]deviceUpdateInfo)
+type FindMyDataRole struct {
+	Emoji      string `json:"emoji"`
+	Identifier int    `json:"identifier"`
+	Name       string `json:"name"`
+}
+
+type FindMyDataSafeLocation struct {
+	Address    FindMyDataAddress  `json:"address"`
+	Identifier string             `json:"identifier"`
+	Location   FindMyDataLocation `json:"location"`
+	Name       string             `json:"name"`
+}
+
+// FindMyDataString accepts a JSON string, number or boolean. Items.data stores
+// some values, such as batteryStatus, as numbers where Devices.data uses
+// strings.
+type FindMyDataString string
+
+func (fmds *FindMyDataString) UnmarshalJSON(data []byte) error {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case nil:
+		*fmds = ""
+	case string:
+		*fmds = FindMyDataString(v)
+	case float64:
+		*fmds = FindMyDataString(strconv.FormatFloat(v, 'f', -1, 64))
+	case bool:
+		*fmds = FindMyDataString(strconv.FormatBool(v))
+	default:
+		return fmt.Errorf("unexpected value %s", string(data))
+	}
+	return nil
+}
+
+type FindMyDevice struct {
+	FindMyData
+}
+
+const (
+	defaultCacheDirectory = "Library/Caches/com.apple.findmy.fmipcore"
+)
 
 type FileCacheReaderParams struct {
 	fx.In
@@ -58,14 +153,37 @@ type FileCacheReaderParams struct {
 	Logger logging.Logger
 }
 
+// cacheFileState remembers the fingerprint of a cache file and its parsed
+// content, so that a file that did not change is never parsed twice.
+type cacheFileState struct {
+	findMyDevices []FindMyDevice
+	hash          [sha256.Size]byte
+	modTime       time.Time
+	size          int64
+}
+
 type fileCacheReader struct {
-	logger logging.Logger
+	cacheFiles   map[string]cacheFileState
+	cacheFilesMu sync.Mutex
+	cacheKey     []byte
+	cacheKeyErr  error
+	config       config.Config
+	knownDevices sync.Map
+	logger       logging.Logger
 }
 
 func NewFileCacheReader(fcrp FileCacheReaderParams) interfaces.IFileCacheReader {
-	return &fileCacheReader{
-		logger: fcrp.Logger,
+	const names = "__file_cache_reader.go__: NewFileCacheReader"
+	fcr := &fileCacheReader{
+		cacheFiles: make(map[string]cacheFileState),
+		config:     fcrp.Config,
+		logger:     fcrp.Logger,
+	}
+	fcr.cacheKey, fcr.cacheKeyErr = loadCacheKey(fcrp.Config.CacheKey, fcrp.Config.CacheKeyPath)
+	if fcr.cacheKeyErr != nil {
+		fcr.logger.Error(fmt.Sprintf("%s | %s", names, fcr.cacheKeyErr.Error()))
 	}
+	return fcr
 }
 
 func (fcr *fileCacheReader) CalcAccuracy(horizontalAccuracy, verticalAccuracy float64) float64 {
@@ -73,23 +191,106 @@ func (fcr *fileCacheReader) CalcAccuracy(horizontalAccuracy, verticalAccuracy fl
 }
 
 func (fcr *fileCacheReader) ConvertToDevice(data any) entities.Device {
+	const names = "__file_cache_reader.go__: ConvertToDevice"
 	findMyDevice := data.(FindMyDevice)
 	timestamp := findMyDevice.Location.TimeStamp
 	lastUpdate := time.Unix(timestamp/1000, (timestamp%1000)*1000000)
 	sourceType := fcr.GetSourceType(findMyDevice.Location.PositionType)
 	gpsAccuracy := fcr.CalcAccuracy(findMyDevice.Location.HorizontalAccuracy, findMyDevice.Location.VerticalAccuracy)
+	modelName := findMyDevice.ModelName
+	if modelName == "" {
+		modelName = findMyDevice.ProductType.ProductInformation.ModelName
+	}
+	identifier := ""
+	if fcr.config.DeviceIDStrategy != config.DeviceIDStrategyName {
+		identifier = findMyDevice.StableIdentifier()
+	}
 
-	return *entities.NewDevice(
+	device := *entities.NewDevice(
 		findMyDevice.Address.FullAddress,
-		findMyDevice.BatteryStatus,
+		string(findMyDevice.BatteryStatus),
 		gpsAccuracy,
+		identifier,
 		lastUpdate,
 		findMyDevice.Location.Latitude,
 		findMyDevice.Location.Longitude,
-		findMyDevice.ModelName,
+		modelName,
 		findMyDevice.Name,
 		sourceType,
 	)
+	if findMyDevice.BatteryLevel != nil {
+		batteryLevel := math.Round(*findMyDevice.BatteryLevel * 100)
+		device.BatteryLevel = &batteryLevel
+	}
+	device.LowPowerMode = findMyDevice.LowPowerMode
+	device.Altitude = findMyDevice.Location.Altitude
+	device.FloorLevel = int(findMyDevice.Location.FloorLevel)
+	device.IsOld = findMyDevice.Location.IsOld
+	device.IsInaccurate = findMyDevice.Location.IsInaccurate
+	device.AddressDetails = convertToDeviceAddress(findMyDevice.Address)
+	device.ProductType = findMyDevice.ProductType.Type
+	device.Role = entities.DeviceRole{
+		Emoji:      findMyDevice.Role.Emoji,
+		Identifier: findMyDevice.Role.Identifier,
+		Name:       findMyDevice.Role.Name,
+	}
+	if findMyDevice.CrowdSourcedLocation != nil {
+		crowdSourcedLocation := fcr.convertToDeviceLocation(*findMyDevice.CrowdSourcedLocation)
+		device.CrowdSourcedLocation = &crowdSourcedLocation
+	}
+	if len(findMyDevice.PartInfo) > 0 {
+		var partInfo map[string]any
+		if err := json.Unmarshal(findMyDevice.PartInfo, &partInfo); err != nil {
+			fcr.logger.Debug(fmt.Sprintf("%s | Device %q: ignoring partInfo: %s", names, findMyDevice.Name, err.Error()))
+		}
+		device.PartInfo = partInfo
+	}
+	for _, safeLocation := range findMyDevice.SafeLocations {
+		device.SafeLocations = append(device.SafeLocations, entities.DeviceSafeLocation{
+			Address:    safeLocation.Address.FullAddress,
+			Identifier: safeLocation.Identifier,
+			Latitude:   safeLocation.Location.Latitude,
+			Longitude:  safeLocation.Location.Longitude,
+			Name:       safeLocation.Name,
+		})
+	}
+	if _, known := fcr.knownDevices.LoadOrStore(device.ID, struct{}{}); !known {
+		fcr.logger.Info(fmt.Sprintf("%s | Device %q has id %q (name based id %q)", names, device.Name, device.ID, device.ObjectID))
+	}
+	return device
+}
+
+func (fcr *fileCacheReader) convertToDeviceLocation(location FindMyDataLocation) entities.DeviceLocation {
+	return entities.DeviceLocation{
+		Altitude:     location.Altitude,
+		FloorLevel:   int(location.FloorLevel),
+		GPSAccuracy:  fcr.CalcAccuracy(location.HorizontalAccuracy, location.VerticalAccuracy),
+		IsInaccurate: location.IsInaccurate,
+		IsOld:        location.IsOld,
+		LastUpdate:   time.Unix(location.TimeStamp/1000, (location.TimeStamp%1000)*1000000),
+		Latitude:     location.Latitude,
+		Longitude:    location.Longitude,
+		PositionType: location.PositionType,
+	}
+}
+
+func convertToDeviceAddress(address FindMyDataAddress) entities.DeviceAddress {
+	streetAddress := address.FullThoroughfare
+	if streetAddress == "" {
+		streetAddress = strings.TrimSpace(address.StreetAddress + " " + address.StreetName)
+	}
+	return entities.DeviceAddress{
+		AdministrativeArea:    address.AdministrativeArea,
+		Country:               address.Country,
+		CountryCode:           address.CountryCode,
+		FormattedAddressLines: address.FormattedAddressLines,
+		Locality:              address.Locality,
+		PostalCode:            address.PostalCode,
+		StateCode:             address.StateCode,
+		StreetAddress:         streetAddress,
+		StreetName:            address.StreetName,
+		SubAdministrativeArea: address.SubAdministrativeArea,
+	}
 }
 
 func (fcr *fileCacheReader) GetSourceType(applePositionType string) string {
@@ -103,57 +304,142 @@ func (fcr *fileCacheReader) GetSourceType(applePositionType string) string {
 	}
 }
 
-func (fcr *fileCacheReader) HasDeviceMustBeUpdated(id, name string, lastUpdate time.Time) bool {
-	updatesIdentifier := fmt.Sprintf("%s (%s)", name, id)
-	lastUpdateInfo, exists := deviceUpdates[updatesIdentifier]
-	updated := exists && lastUpdateInfo.LastUpdateTime.Equal(lastUpdate)
+func (fcr *fileCacheReader) ReadDevicesData() ([]entities.Device, error) {
+	const names = "__file_cache_reader.go__: ReadDevicesData"
+	sources, err := fcr.getCacheSources()
+	if err != nil {
+		return nil, fmt.Errorf("%s | %w", names, err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(len(sources))
+
+	results := make([][]FindMyDevice, len(sources))
+	errs := make([]error, len(sources))
 
-	deviceUpdates[updatesIdentifier] = deviceUpdateInfo{
-		LastUpdateTime: lastUpdate,
+	for i, source := range sources {
+		go func(i int, source config.CacheSource) {
+			defer wg.Done()
+			results[i], errs[i] = fcr.readCacheSource(source)
+		}(i, source)
 	}
-	return updated
+
+	wg.Wait()
+
+	var devices []entities.Device
+	var requiredErrs []error
+	for i, source := range sources {
+		if errs[i] != nil {
+			if source.Required {
+				requiredErrs = append(requiredErrs, errs[i])
+			} else {
+				fcr.logger.Warn(fmt.Sprintf("%s | %s", names, errs[i].Error()))
+			}
+			continue
+		}
+		for _, findMyDevice := range results[i] {
+			device := fcr.ConvertToDevice(findMyDevice)
+			device.Source = source.Name
+			devices = append(devices, device)
+		}
+	}
+	if len(requiredErrs) > 0 {
+		return nil, fmt.Errorf("%s | %w", names, errors.Join(requiredErrs...))
+	}
+
+	return devices, nil
 }
 
-func (fcr *fileCacheReader) ReadDevicesData() ([]entities.Device, error) {
-	const names = "__file_cache_reader.go__: ReadDevicesData"
+// getCacheSources returns the configured cache sources, falling back to the
+// FindMy cache directory of the current user when none are configured.
+func (fcr *fileCacheReader) getCacheSources() ([]config.CacheSource, error) {
+	if len(fcr.config.CacheSources) > 0 {
+		return fcr.config.CacheSources, nil
+	}
 	usr, err := user.Current()
-
 	if err != nil {
-		return nil, fmt.Errorf("%s | error getting current user: %w", names, err)
+		return nil, fmt.Errorf("error getting current user: %w", err)
 	}
+	cacheDirectory := filepath.Join(usr.HomeDir, defaultCacheDirectory)
+	return []config.CacheSource{
+		{Name: "devices", Path: cacheDirectory, Type: config.CacheSourceTypeDevices},
+		{Name: "items", Path: cacheDirectory, Type: config.CacheSourceTypeItems},
+	}, nil
+}
 
-	filePathDevices := filepath.Join(usr.HomeDir, "Library/Caches/com.apple.findmy.fmipcore/Devices.data")
-	filePathItems := filepath.Join(usr.HomeDir, "Library/Caches/com.apple.findmy.fmipcore/Items.data")
-
-	var wg sync.WaitGroup
-	wg.Add(2)
+func (fcr *fileCacheReader) readCacheSource(source config.CacheSource) ([]FindMyDevice, error) {
+	const names = "__file_cache_reader.go__: readCacheSource"
+	filePath, err := source.FilePath()
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("cache source %q: %w", source.Name, err)
+		}
+		if source.Required {
+			return nil, fmt.Errorf("cache source %q: required %s file %s not found", source.Name, source.Type, filePath)
+		}
+		fcr.logger.Debug(fmt.Sprintf("%s | cache source %q: optional %s file %s not found, skipping", names, source.Name, source.Type, filePath))
+		return nil, nil
+	}
+	findMyDevices, err := fcr.readCacheFile(filePath, info)
+	if err != nil {
+		return nil, fmt.Errorf("cache source %q: %w", source.Name, err)
+	}
+	return findMyDevices, nil
+}
 
-	var devicesData, itemsData []FindMyDevice
-	var errDevices, errItems error
+// readCacheFile returns the parsed content of a cache file. The file is only
+// read again when its modification time or size changed, and only parsed
+// again when its content hash changed.
+func (fcr *fileCacheReader) readCacheFile(filePath string, info fs.FileInfo) ([]FindMyDevice, error) {
+	const names = "__file_cache_reader.go__: readCacheFile"
+	fcr.cacheFilesMu.Lock()
+	state, exists := fcr.cacheFiles[filePath]
+	fcr.cacheFilesMu.Unlock()
+	if exists && state.modTime.Equal(info.ModTime()) && state.size == info.Size() {
+		return state.findMyDevices, nil
+	}
 
-	go func() {
-		defer wg.Done()
-		devicesData, errDevices = readAndUnmarshalData(filePathDevices)
-		if errDevices != nil {
-			fcr.logger.Warn(fmt.Sprintf("%s | %s", names, errDevices.Error()))
-		}
-	}()
-	go func() {
-		defer wg.Done()
-		itemsData, errItems = readAndUnmarshalData(filePathItems)
-		if errItems != nil {
-			fcr.logger.Warn(fmt.Sprintf("%s | %s", names, errItems.Error()))
+	data, err := readData(filePath)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(data)
+	if !exists || state.hash != hash {
+		findMyDevices, err := fcr.unmarshalCacheData(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filePath, err)
 		}
-	}()
+		state.findMyDevices = findMyDevices
+		fcr.logger.Debug(fmt.Sprintf("%s | parsed %d records from %s", names, len(findMyDevices), filePath))
+	}
+	state.hash = hash
+	state.modTime = info.ModTime()
+	state.size = info.Size()
 
-	wg.Wait()
+	fcr.cacheFilesMu.Lock()
+	fcr.cacheFiles[filePath] = state
+	fcr.cacheFilesMu.Unlock()
+	return state.findMyDevices, nil
+}
 
-	data := append(devicesData, itemsData...)
-	devices := make([]entities.Device, len(data))
-	for i, findMyDevice := range data {
-		devices[i] = fcr.ConvertToDevice(findMyDevice)
+func (fcr *fileCacheReader) GetCacheFilePaths() ([]string, error) {
+	sources, err := fcr.getCacheSources()
+	if err != nil {
+		return nil, err
 	}
-	return devices, nil
+	filePaths := make([]string, 0, len(sources))
+	for _, source := range sources {
+		filePath, err := source.FilePath()
+		if err != nil {
+			return nil, err
+		}
+		filePaths = append(filePaths, filePath)
+	}
+	return filePaths, nil
 }
 
 func readData(filePath string) ([]byte, error) {
@@ -164,11 +450,25 @@ func readData(filePath string) ([]byte, error) {
 	return data, nil
 }
 
-func readAndUnmarshalData(filePath string) ([]FindMyDevice, error) {
-	data, err := readData(filePath)
-	if err != nil {
-		return nil, err
+// unmarshalCacheData decodes the content of a cache file, decrypting it first
+// when it is an encrypted container.
+func (fcr *fileCacheReader) unmarshalCacheData(data []byte) ([]FindMyDevice, error) {
+	if isPlist(data) {
+		if fcr.cacheKeyErr != nil {
+			return nil, fcr.cacheKeyErr
+		}
+		payload, err := decryptCacheData(data, fcr.cacheKey)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = decodeCachePayload(payload); err != nil {
+			return nil, err
+		}
 	}
+	return unmarshalData(data)
+}
+
+func unmarshalData(data []byte) ([]FindMyDevice, error) {
 	var findMyDevices []FindMyDevice
 	if err := json.Unmarshal(data, &findMyDevices); err != nil {
 		return nil, err
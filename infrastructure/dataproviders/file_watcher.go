@@ -0,0 +1,197 @@
+package dataproviders
+
+import (
+	"apple-findmy-to-mqtt/infrastructure/logging"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fileWatcher notifies about changes of a fixed set of files. It watches the
+// parent directories with fsnotify, so that files replaced by an atomic rename
+// are still tracked, and falls back to polling the files when fsnotify is not
+// available. Bursts of changes are coalesced into a single notification.
+type fileWatcher struct {
+	debounce     time.Duration
+	forcePolling bool
+	logger       logging.Logger
+	paths        map[string]struct{}
+	pollInterval time.Duration
+}
+
+type fileStat struct {
+	exists  bool
+	modTime time.Time
+	size    int64
+}
+
+func newFileWatcher(logger logging.Logger, paths []string, debounce, pollInterval time.Duration, forcePolling bool) *fileWatcher {
+	fw := &fileWatcher{
+		debounce:     debounce,
+		forcePolling: forcePolling,
+		logger:       logger,
+		paths:        make(map[string]struct{}, len(paths)),
+		pollInterval: pollInterval,
+	}
+	for _, path := range paths {
+		fw.paths[absolutePath(path)] = struct{}{}
+	}
+	return fw
+}
+
+func absolutePath(path string) string {
+	if absolute, err := filepath.Abs(path); err == nil {
+		return absolute
+	}
+	return filepath.Clean(path)
+}
+
+// Watch blocks until ctx is done and calls onChange with the sorted list of
+// changed files once no further change happened during the debounce delay.
+func (fw *fileWatcher) Watch(ctx context.Context, onChange func(changed []string)) error {
+	const names = "__file_watcher.go__: Watch"
+	changes := make(chan string)
+	if fw.forcePolling {
+		go fw.poll(ctx, changes)
+	} else if watcher, err := fw.newNotifyWatcher(); err != nil {
+		fw.logger.Warn(fmt.Sprintf("%s | %s, falling back to polling every %s", names, err.Error(), fw.pollInterval))
+		go fw.poll(ctx, changes)
+	} else {
+		defer watcher.Close()
+		go fw.notify(ctx, watcher, changes)
+	}
+
+	var timer *time.Timer
+	var timerC <-chan time.Time
+	pending := make(map[string]struct{})
+	for {
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return nil
+		case path := <-changes:
+			pending[path] = struct{}{}
+			if timer == nil {
+				timer = time.NewTimer(fw.debounce)
+			} else {
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(fw.debounce)
+			}
+			timerC = timer.C
+		case <-timerC:
+			timerC = nil
+			changed := make([]string, 0, len(pending))
+			for path := range pending {
+				changed = append(changed, path)
+			}
+			sort.Strings(changed)
+			pending = make(map[string]struct{})
+			onChange(changed)
+		}
+	}
+}
+
+func (fw *fileWatcher) newNotifyWatcher() (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("error creating file watcher: %w", err)
+	}
+	directories := make(map[string]struct{})
+	for path := range fw.paths {
+		directories[filepath.Dir(path)] = struct{}{}
+	}
+	for directory := range directories {
+		if err := watcher.Add(directory); err != nil {
+			watcher.Close()
+			return nil, fmt.Errorf("error watching directory %s: %w", directory, err)
+		}
+	}
+	return watcher, nil
+}
+
+func (fw *fileWatcher) notify(ctx context.Context, watcher *fsnotify.Watcher, changes chan<- string) {
+	const names = "__file_watcher.go__: notify"
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) && !event.Has(fsnotify.Rename) && !event.Has(fsnotify.Remove) {
+				continue
+			}
+			path := absolutePath(event.Name)
+			if _, tracked := fw.paths[path]; !tracked {
+				continue
+			}
+			select {
+			case changes <- path:
+			case <-ctx.Done():
+				return
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			fw.logger.Warn(fmt.Sprintf("%s | %s", names, err.Error()))
+		}
+	}
+}
+
+func (fw *fileWatcher) poll(ctx context.Context, changes chan<- string) {
+	stats := make(map[string]fileStat, len(fw.paths))
+	for path := range fw.paths {
+		stats[path] = statFile(path)
+	}
+	ticker := time.NewTicker(fw.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for path, previous := range stats {
+				current := statFile(path)
+				if current.equal(previous) {
+					continue
+				}
+				stats[path] = current
+				select {
+				case changes <- path:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}
+}
+
+func statFile(path string) fileStat {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStat{}
+	}
+	return fileStat{
+		exists:  true,
+		modTime: info.ModTime(),
+		size:    info.Size(),
+	}
+}
+
+func (fst fileStat) equal(other fileStat) bool {
+	return fst.exists == other.exists && fst.size == other.size && fst.modTime.Equal(other.modTime)
+}
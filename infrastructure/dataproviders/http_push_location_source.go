@@ -0,0 +1,173 @@
+package dataproviders
+
+import (
+	"apple-findmy-to-mqtt/core/entities"
+	"apple-findmy-to-mqtt/core/interfaces"
+	"apple-findmy-to-mqtt/infrastructure/config"
+	"apple-findmy-to-mqtt/infrastructure/logging"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+const httpPushMaxBodySize = 10 << 20
+
+type HTTPPushLocationSourceParams struct {
+	fx.In
+	Config          config.Config
+	FileCacheReader interfaces.IFileCacheReader
+	Lifecycle       fx.Lifecycle
+	Logger          logging.Logger
+}
+
+// httpPushLocationSource receives FindMy records pushed over HTTP, e.g. by a
+// script running on another Mac, and keeps the latest fix of each device
+// until the device is no longer pushed for the cleanup grace period.
+type httpPushLocationSource struct {
+	devices         map[string]pushedDevice
+	fileCacheReader interfaces.IFileCacheReader
+	logger          logging.Logger
+	mu              sync.Mutex
+	token           string
+	ttl             time.Duration
+}
+
+type pushedDevice struct {
+	device   entities.Device
+	pushedAt time.Time
+}
+
+// NewHTTPPushLocationSource returns the source fed over HTTP. The server only
+// listens on http_push_listen once the application started, and a listen
+// error aborts the start.
+func NewHTTPPushLocationSource(hplsp HTTPPushLocationSourceParams) interfaces.ILocationSource {
+	const names = "__http_push_location_source.go__: NewHTTPPushLocationSource"
+	hpls := &httpPushLocationSource{
+		devices:         make(map[string]pushedDevice),
+		fileCacheReader: hplsp.FileCacheReader,
+		logger:          hplsp.Logger,
+		token:           hplsp.Config.LocationSources.HTTPPushToken,
+		ttl:             time.Duration(hplsp.Config.CleanupGracePeriod) * time.Second,
+	}
+	listen := hplsp.Config.LocationSources.HTTPPushListen
+	if listen == "" {
+		return hpls
+	}
+	if hpls.token == "" {
+		hpls.logger.Warn(fmt.Sprintf("%s | http_push_token is empty, anyone reaching %s can push device locations", names, listen))
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/devices", hpls.handleDevices)
+	server := &http.Server{
+		Addr:              listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	hplsp.Lifecycle.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			listener, err := net.Listen("tcp", listen)
+			if err != nil {
+				return fmt.Errorf("http push: %w", err)
+			}
+			hpls.logger.Info(fmt.Sprintf("%s | Listening on %s", names, listener.Addr()))
+			go func() {
+				if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					hpls.logger.Error(fmt.Sprintf("%s | %s", names, err.Error()))
+				}
+			}()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			return server.Shutdown(ctx)
+		},
+	})
+	return hpls
+}
+
+func (hpls *httpPushLocationSource) Name() string {
+	return "http_push"
+}
+
+// ReadDevices returns the latest fix of every device pushed within the
+// cleanup grace period, and forgets the others so that they are removed like
+// any device gone from FindMy. Nothing is forgotten when the cleanup is
+// disabled.
+func (hpls *httpPushLocationSource) ReadDevices() ([]entities.Device, error) {
+	const names = "__http_push_location_source.go__: ReadDevices"
+	now := time.Now()
+	hpls.mu.Lock()
+	defer hpls.mu.Unlock()
+	devices := make([]entities.Device, 0, len(hpls.devices))
+	for id, pushed := range hpls.devices {
+		if hpls.ttl > 0 && now.Sub(pushed.pushedAt) > hpls.ttl {
+			hpls.logger.Info(fmt.Sprintf("%s | %s was not pushed for %s, forgetting it", names, id, hpls.ttl))
+			delete(hpls.devices, id)
+			continue
+		}
+		devices = append(devices, pushed.device)
+	}
+	return devices, nil
+}
+
+// handleDevices accepts a JSON array of FindMy records, in the format of the
+// cache files, or a single record.
+func (hpls *httpPushLocationSource) handleDevices(w http.ResponseWriter, r *http.Request) {
+	const names = "__http_push_location_source.go__: handleDevices"
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if hpls.token != "" {
+		expected := "Bearer " + hpls.token
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expected)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, httpPushMaxBodySize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var findMyDevices []FindMyDevice
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '{' {
+		var findMyDevice FindMyDevice
+		err = json.Unmarshal(trimmed, &findMyDevice)
+		findMyDevices = append(findMyDevices, findMyDevice)
+	} else {
+		findMyDevices, err = unmarshalData(trimmed)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	hpls.mu.Lock()
+	for _, findMyDevice := range findMyDevices {
+		device := hpls.fileCacheReader.ConvertToDevice(findMyDevice)
+		// An older fix still tells that the device is pushed.
+		if current, exists := hpls.devices[device.ID]; exists && current.device.LastUpdate.After(device.LastUpdate) {
+			device = current.device
+		}
+		hpls.devices[device.ID] = pushedDevice{device: device, pushedAt: now}
+	}
+	hpls.mu.Unlock()
+
+	hpls.logger.Debug(fmt.Sprintf("%s | Received %d records from %s", names, len(findMyDevices), r.RemoteAddr))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(map[string]int{"accepted": len(findMyDevices)})
+}
@@ -0,0 +1,112 @@
+package dataproviders
+
+import (
+	"apple-findmy-to-mqtt/core/entities"
+	"apple-findmy-to-mqtt/core/interfaces"
+	"apple-findmy-to-mqtt/infrastructure/logging"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// fakeFileCacheReader converts records with their identifier as ID. The
+// embedded interface is nil, only ConvertToDevice is expected to be called.
+type fakeFileCacheReader struct {
+	interfaces.IFileCacheReader
+}
+
+func (ffcr fakeFileCacheReader) ConvertToDevice(data any) entities.Device {
+	findMyDevice := data.(FindMyDevice)
+	return entities.Device{
+		ID:         findMyDevice.Identifier,
+		LastUpdate: time.UnixMilli(findMyDevice.Location.TimeStamp),
+	}
+}
+
+func newTestHTTPPushLocationSource(ttl time.Duration) *httpPushLocationSource {
+	return &httpPushLocationSource{
+		devices:         make(map[string]pushedDevice),
+		fileCacheReader: fakeFileCacheReader{},
+		logger:          logging.Logger{SugaredLogger: zap.NewNop().Sugar()},
+		token:           "secret",
+		ttl:             ttl,
+	}
+}
+
+func readDeviceIDs(t *testing.T, hpls *httpPushLocationSource) []string {
+	t.Helper()
+	devices, err := hpls.ReadDevices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, 0, len(devices))
+	for _, device := range devices {
+		ids = append(ids, device.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func push(t *testing.T, hpls *httpPushLocationSource, token, body string) int {
+	t.Helper()
+	request := httptest.NewRequest(http.MethodPost, "/devices", strings.NewReader(body))
+	request.Header.Set("Authorization", "Bearer "+token)
+	recorder := httptest.NewRecorder()
+	hpls.handleDevices(recorder, request)
+	return recorder.Code
+}
+
+func TestHTTPPushLocationSourcePush(t *testing.T) {
+	hpls := newTestHTTPPushLocationSource(time.Hour)
+	if code := push(t, hpls, "wrong", `{"identifier":"a"}`); code != http.StatusUnauthorized {
+		t.Fatalf("got status %d with a wrong token, want %d", code, http.StatusUnauthorized)
+	}
+	if code := push(t, hpls, "secret", `[{"identifier":"a","location":{"timeStamp":2000}},{"identifier":"b"}]`); code != http.StatusAccepted {
+		t.Fatalf("got status %d, want %d", code, http.StatusAccepted)
+	}
+	// An older fix does not replace the latest one.
+	if code := push(t, hpls, "secret", `{"identifier":"a","location":{"timeStamp":1000}}`); code != http.StatusAccepted {
+		t.Fatalf("got status %d, want %d", code, http.StatusAccepted)
+	}
+
+	if got := readDeviceIDs(t, hpls); strings.Join(got, ",") != "a,b" {
+		t.Fatalf("got %v, want [a b]", got)
+	}
+	if got := hpls.devices["a"].device.LastUpdate.UnixMilli(); got != 2000 {
+		t.Fatalf("got fix %d, want 2000", got)
+	}
+}
+
+func TestHTTPPushLocationSourceForgetsDevicesNoLongerPushed(t *testing.T) {
+	now := time.Now()
+	hpls := newTestHTTPPushLocationSource(time.Hour)
+	hpls.devices["gone"] = pushedDevice{device: entities.Device{ID: "gone"}, pushedAt: now.Add(-2 * time.Hour)}
+	hpls.devices["pushed"] = pushedDevice{device: entities.Device{ID: "pushed"}, pushedAt: now.Add(-10 * time.Minute)}
+
+	if got := readDeviceIDs(t, hpls); strings.Join(got, ",") != "pushed" {
+		t.Fatalf("got %v, want [pushed]", got)
+	}
+	if _, exists := hpls.devices["gone"]; exists {
+		t.Fatal("the device no longer pushed was kept")
+	}
+
+	// Pushing an older fix again keeps the device.
+	hpls.devices["pushed"] = pushedDevice{device: entities.Device{ID: "pushed", LastUpdate: time.UnixMilli(5000)}, pushedAt: now.Add(-2 * time.Hour)}
+	push(t, hpls, "secret", `{"identifier":"pushed","location":{"timeStamp":1000}}`)
+	if got := readDeviceIDs(t, hpls); strings.Join(got, ",") != "pushed" {
+		t.Fatalf("got %v after a new push, want [pushed]", got)
+	}
+}
+
+func TestHTTPPushLocationSourceKeepsDevicesWithoutCleanup(t *testing.T) {
+	hpls := newTestHTTPPushLocationSource(0)
+	hpls.devices["old"] = pushedDevice{device: entities.Device{ID: "old"}, pushedAt: time.Now().Add(-365 * 24 * time.Hour)}
+	if got := readDeviceIDs(t, hpls); strings.Join(got, ",") != "old" {
+		t.Fatalf("got %v, want [old]", got)
+	}
+}
@@ -0,0 +1,45 @@
+package dataproviders
+
+import (
+	"apple-findmy-to-mqtt/core/entities"
+	"apple-findmy-to-mqtt/core/interfaces"
+	"apple-findmy-to-mqtt/infrastructure/config"
+
+	"go.uber.org/fx"
+)
+
+type IgnoredDeviceFileParams struct {
+	fx.In
+	Config config.Config
+}
+
+type ignoredDeviceFile struct {
+	path string
+}
+
+func NewIgnoredDeviceFile(idfp IgnoredDeviceFileParams) interfaces.IIgnoredDeviceFile {
+	return &ignoredDeviceFile{
+		path: idfp.Config.IgnoredDevicesPath,
+	}
+}
+
+// LoadDevices returns the devices saved in the file, none when the file does
+// not exist yet.
+func (idf *ignoredDeviceFile) LoadDevices() (entities.IgnoredDeviceMap, error) {
+	devices := make(entities.IgnoredDeviceMap)
+	if idf.path == "" {
+		return devices, nil
+	}
+	if err := readJSONFile(idf.path, &devices); err != nil {
+		return make(entities.IgnoredDeviceMap), err
+	}
+	return devices, nil
+}
+
+// SaveDevices replaces the content of the file with devices.
+func (idf *ignoredDeviceFile) SaveDevices(devices entities.IgnoredDeviceMap) error {
+	if idf.path == "" {
+		return nil
+	}
+	return writeJSONAtomic(idf.path, devices)
+}
@@ -5,42 +5,229 @@ import (
 	"apple-findmy-to-mqtt/core/interfaces"
 	"apple-findmy-to-mqtt/infrastructure/config"
 	"apple-findmy-to-mqtt/infrastructure/logging"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
+	"math"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"go.uber.org/fx"
 )
 
 type Location struct {
-	Latitude  float64 `json:"latitude"`
-	Longitude float64 `json:"longitude"`
-	Tolerance float64 `json:"tolerance"`
+	Dwell         *float64 `json:"dwell"`
+	ExitTolerance float64  `json:"exit_tolerance"`
+	Latitude      float64  `json:"latitude"`
+	Longitude     float64  `json:"longitude"`
+	Priority      int      `json:"priority"`
+	Tolerance     float64  `json:"tolerance"`
 }
 
 type LocationMap map[string]Location
 
+type GeoJSONFeatureCollection struct {
+	Features []GeoJSONFeature `json:"features"`
+	Type     string           `json:"type"`
+}
+
+type GeoJSONFeature struct {
+	Geometry   GeoJSONGeometry   `json:"geometry"`
+	Properties GeoJSONProperties `json:"properties"`
+	Type       string            `json:"type"`
+}
+
+type GeoJSONGeometry struct {
+	Coordinates json.RawMessage `json:"coordinates"`
+	Type        string          `json:"type"`
+}
+
+type GeoJSONProperties struct {
+	Buffer     float64  `json:"buffer"`
+	Dwell      *float64 `json:"dwell"`
+	ExitBuffer float64  `json:"exit_buffer"`
+	Name       string   `json:"name"`
+	Priority   int      `json:"priority"`
+}
+
 type KnownLocationFileParams struct {
 	fx.In
-	Config config.Config
-	Logger logging.Logger
+	Config    config.Config
+	Lifecycle fx.Lifecycle
+	Logger    logging.Logger
 }
 type knownLocationFile struct {
-	config    config.Config
-	logger    logging.Logger
-	locations entities.KnownLocationMap
+	config      config.Config
+	listeners   []func()
+	listenersMu sync.Mutex
+	logger      logging.Logger
+	locations   entities.KnownLocationMap
+	locationsMu sync.RWMutex
 }
 
+// NewKnownLocationFile loads the known locations and, once the application
+// started, reloads them whenever one of the files changes or the process
+// receives SIGHUP. SIGHUP is caught from now on, as its default action
+// terminates the process, and handled once the application started.
 func NewKnownLocationFile(klfp KnownLocationFileParams) interfaces.IKnownLocationFile {
+	const names = "__known_location_file.go__: NewKnownLocationFile"
 	klf := &knownLocationFile{
-		logger: klfp.Logger,
-		config: klfp.Config,
+		logger:    klfp.Logger,
+		config:    klfp.Config,
+		locations: make(entities.KnownLocationMap),
 	}
-	locations, _ := klf.LoadLocationsFromFile(klfp.Config.KnownLocationsPath)
-	klf.locations = locations
+	if err := klf.load(); err != nil {
+		klf.logger.Error(fmt.Sprintf("%s | %s", names, err.Error()))
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	ctx, cancel := context.WithCancel(context.Background())
+	klfp.Lifecycle.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go klf.watch(ctx, signals)
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			signal.Stop(signals)
+			cancel()
+			return nil
+		},
+	})
 	return klf
 }
 
+func (klf *knownLocationFile) watch(ctx context.Context, signals <-chan os.Signal) {
+	const names = "__known_location_file.go__: watch"
+	filePaths := []string{klf.config.KnownLocationsPath}
+	if klf.config.KnownLocationsGeoJSONPath != "" {
+		filePaths = append(filePaths, klf.config.KnownLocationsGeoJSONPath)
+	}
+	watcher := newFileWatcher(
+		klf.logger,
+		filePaths,
+		time.Duration(klf.config.WatchDebounce)*time.Millisecond,
+		time.Duration(klf.config.WatchPollInterval)*time.Second,
+		klf.config.ScanMode == config.ScanModePoll,
+	)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-signals:
+				klf.logger.Info(fmt.Sprintf("%s | SIGHUP received, reloading known locations", names))
+				klf.reloadOrLog()
+			}
+		}
+	}()
+
+	if err := watcher.Watch(ctx, func(changed []string) {
+		klf.logger.Info(fmt.Sprintf("%s | %s changed, reloading known locations", names, strings.Join(changed, ", ")))
+		klf.reloadOrLog()
+	}); err != nil {
+		klf.logger.Error(fmt.Sprintf("%s | %s", names, err.Error()))
+	}
+}
+
+func (klf *knownLocationFile) reloadOrLog() {
+	const names = "__known_location_file.go__: reloadOrLog"
+	if err := klf.Reload(); err != nil {
+		klf.logger.Error(fmt.Sprintf("%s | %s, keeping the previous known locations", names, err.Error()))
+	}
+}
+
+// Reload reloads the known locations and, on success, calls the listeners
+// registered with OnReload.
+func (klf *knownLocationFile) Reload() error {
+	if err := klf.load(); err != nil {
+		return err
+	}
+	klf.listenersMu.Lock()
+	listeners := append([]func(){}, klf.listeners...)
+	klf.listenersMu.Unlock()
+	for _, listener := range listeners {
+		listener()
+	}
+	return nil
+}
+
+// load loads the JSON and GeoJSON files and replaces the known locations only
+// when both could be parsed. A missing JSON file means no circle zones.
+func (klf *knownLocationFile) load() error {
+	const names = "__known_location_file.go__: load"
+	locations, err := klf.LoadLocationsFromFile(klf.config.KnownLocationsPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		klf.logger.Warn(fmt.Sprintf("%s | %s", names, err.Error()))
+		locations, err = make(entities.KnownLocationMap), nil
+	}
+	if err != nil {
+		return err
+	}
+	if klf.config.KnownLocationsGeoJSONPath != "" {
+		geoJSONLocations, err := klf.LoadLocationsFromGeoJSONFile(klf.config.KnownLocationsGeoJSONPath)
+		if err != nil {
+			return err
+		}
+		for name, location := range geoJSONLocations {
+			if _, exists := locations[name]; exists {
+				klf.logger.Warn(fmt.Sprintf("%s | Known location %q is defined in both files, using the GeoJSON polygons", names, name))
+			}
+			locations[name] = location
+		}
+	}
+
+	klf.applyDefaults(locations)
+
+	klf.locationsMu.Lock()
+	klf.locations = locations
+	klf.locationsMu.Unlock()
+	klf.logger.Info(fmt.Sprintf("%s | Loaded %d known locations", names, len(locations)))
+	return nil
+}
+
+// applyDefaults fills the radii and dwell time left unset in the files. A
+// negative Dwell means that none was set, zero being a valid dwell time.
+func (klf *knownLocationFile) applyDefaults(locations entities.KnownLocationMap) {
+	margin := float64(klf.config.KnownLocationsExitMargin)
+	for name, location := range locations {
+		if location.Tolerance == 0 {
+			location.Tolerance = float64(klf.config.KnownLocationsDefaultTolerance)
+		}
+		if location.ExitTolerance < location.Tolerance {
+			location.ExitTolerance = location.Tolerance + margin
+		}
+		if location.ExitBuffer < location.Buffer {
+			location.ExitBuffer = location.Buffer + margin
+		}
+		if location.ExitBuffer == 0 && len(location.Polygons) > 0 {
+			location.ExitBuffer = margin
+		}
+		if location.Dwell < 0 {
+			location.Dwell = time.Duration(klf.config.KnownLocationsDwellTime) * time.Second
+		}
+		locations[name] = location
+	}
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+func (klf *knownLocationFile) OnReload(listener func()) {
+	klf.listenersMu.Lock()
+	defer klf.listenersMu.Unlock()
+	klf.listeners = append(klf.listeners, listener)
+}
+
 func (klfp *knownLocationFile) LoadLocationsFromFile(filePath string) (entities.KnownLocationMap, error) {
 	var locations LocationMap
 
@@ -51,12 +238,127 @@ func (klfp *knownLocationFile) LoadLocationsFromFile(filePath string) (entities.
 
 	err = json.Unmarshal(data, &locations)
 	if err != nil {
-		return nil, err
+		return nil, withJSONErrorPosition(filePath, data, err)
 	}
 
 	return klfp.ConvertToKnownLocationMap(locations)
 }
 
+// LoadLocationsFromGeoJSONFile loads the Polygon and MultiPolygon features of a
+// GeoJSON FeatureCollection. Features are named by their "name" property and
+// features sharing a name form a single known location.
+func (klf *knownLocationFile) LoadLocationsFromGeoJSONFile(filePath string) (entities.KnownLocationMap, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var featureCollection GeoJSONFeatureCollection
+	if err := json.Unmarshal(data, &featureCollection); err != nil {
+		return nil, withJSONErrorPosition(filePath, data, err)
+	}
+	if featureCollection.Type == "Feature" {
+		var feature GeoJSONFeature
+		if err := json.Unmarshal(data, &feature); err != nil {
+			return nil, withJSONErrorPosition(filePath, data, err)
+		}
+		featureCollection.Features = []GeoJSONFeature{feature}
+	} else if featureCollection.Type != "FeatureCollection" {
+		return nil, fmt.Errorf("%s: unsupported GeoJSON type %q, expected FeatureCollection or Feature", filePath, featureCollection.Type)
+	}
+
+	knownLocationMap := make(entities.KnownLocationMap)
+	for i, feature := range featureCollection.Features {
+		if feature.Properties.Name == "" {
+			return nil, fmt.Errorf("%s: feature %d has no name property", filePath, i)
+		}
+		polygons, err := convertToPolygons(feature.Geometry)
+		if err != nil {
+			return nil, fmt.Errorf("%s: feature %q: %w", filePath, feature.Properties.Name, err)
+		}
+		location, exists := knownLocationMap[feature.Properties.Name]
+		if !exists {
+			location.Dwell = -1
+		}
+		location.Polygons = append(location.Polygons, polygons...)
+		location.Buffer = math.Max(location.Buffer, feature.Properties.Buffer)
+		location.ExitBuffer = math.Max(location.ExitBuffer, feature.Properties.ExitBuffer)
+		if feature.Properties.Dwell != nil {
+			location.Dwell = secondsToDuration(*feature.Properties.Dwell)
+		}
+		if feature.Properties.Priority > location.Priority {
+			location.Priority = feature.Properties.Priority
+		}
+		knownLocationMap[feature.Properties.Name] = location
+	}
+	for name, location := range knownLocationMap {
+		location.Latitude, location.Longitude = polygonsCentre(location.Polygons)
+		knownLocationMap[name] = location
+	}
+
+	return knownLocationMap, nil
+}
+
+func convertToPolygons(geometry GeoJSONGeometry) ([]entities.Polygon, error) {
+	var rawPolygons [][][][]float64
+	switch geometry.Type {
+	case "Polygon":
+		var rawPolygon [][][]float64
+		if err := json.Unmarshal(geometry.Coordinates, &rawPolygon); err != nil {
+			return nil, err
+		}
+		rawPolygons = append(rawPolygons, rawPolygon)
+	case "MultiPolygon":
+		if err := json.Unmarshal(geometry.Coordinates, &rawPolygons); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported geometry type %q, expected Polygon or MultiPolygon", geometry.Type)
+	}
+
+	polygons := make([]entities.Polygon, 0, len(rawPolygons))
+	for _, rawPolygon := range rawPolygons {
+		if len(rawPolygon) == 0 {
+			return nil, fmt.Errorf("polygon without rings")
+		}
+		polygon := make(entities.Polygon, 0, len(rawPolygon))
+		for _, rawRing := range rawPolygon {
+			if len(rawRing) < 4 {
+				return nil, fmt.Errorf("linear ring with %d positions, expected at least 4", len(rawRing))
+			}
+			ring := make([]entities.Coordinate, 0, len(rawRing))
+			for _, position := range rawRing {
+				if len(position) < 2 {
+					return nil, fmt.Errorf("position with %d values, expected longitude and latitude", len(position))
+				}
+				ring = append(ring, entities.Coordinate{Latitude: position[1], Longitude: position[0]})
+			}
+			polygon = append(polygon, ring)
+		}
+		polygons = append(polygons, polygon)
+	}
+	return polygons, nil
+}
+
+// polygonsCentre returns the average of the vertices of the outer rings, the
+// closing position of each ring excluded.
+func polygonsCentre(polygons []entities.Polygon) (float64, float64) {
+	var latitude, longitude float64
+	count := 0
+	for _, polygon := range polygons {
+		outer := polygon[0]
+		for _, coordinate := range outer[:len(outer)-1] {
+			latitude += coordinate.Latitude
+			longitude += coordinate.Longitude
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, 0
+	}
+	return latitude / float64(count), longitude / float64(count)
+}
+
 func (klf *knownLocationFile) ConvertToKnownLocationMap(data any) (entities.KnownLocationMap, error) {
 	locationMap := data.(LocationMap)
 	locationMap, ok := data.(LocationMap)
@@ -65,16 +367,47 @@ func (klf *knownLocationFile) ConvertToKnownLocationMap(data any) (entities.Know
 	}
 	knownLocationMap := make(entities.KnownLocationMap)
 	for key, location := range locationMap {
-		knownLocationMap[key] = entities.KnownLocation{
-			Latitude:  location.Latitude,
-			Longitude: location.Longitude,
-			Tolerance: location.Tolerance,
+		knownLocation := entities.KnownLocation{
+			Dwell:         -1,
+			ExitTolerance: location.ExitTolerance,
+			Latitude:      location.Latitude,
+			Longitude:     location.Longitude,
+			Priority:      location.Priority,
+			Tolerance:     location.Tolerance,
+		}
+		if location.Dwell != nil {
+			knownLocation.Dwell = secondsToDuration(*location.Dwell)
 		}
+		knownLocationMap[key] = knownLocation
 	}
 
 	return knownLocationMap, nil
 }
 
 func (klf *knownLocationFile) GetAllLocations() entities.KnownLocationMap {
+	klf.locationsMu.RLock()
+	defer klf.locationsMu.RUnlock()
 	return klf.locations
 }
+
+// withJSONErrorPosition prefixes a decoding error with the file path and, when
+// known, the line and column of the offending byte.
+func withJSONErrorPosition(filePath string, data []byte, err error) error {
+	var offset int64 = -1
+	var syntaxError *json.SyntaxError
+	var unmarshalTypeError *json.UnmarshalTypeError
+	if errors.As(err, &syntaxError) {
+		offset = syntaxError.Offset
+	} else if errors.As(err, &unmarshalTypeError) {
+		offset = unmarshalTypeError.Offset
+	}
+	if offset < 0 {
+		return fmt.Errorf("%s: %w", filePath, err)
+	}
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	line := 1 + bytes.Count(data[:offset], []byte("\n"))
+	column := int(offset) - bytes.LastIndexByte(data[:offset], '\n')
+	return fmt.Errorf("%s:%d:%d: %w", filePath, line, column, err)
+}
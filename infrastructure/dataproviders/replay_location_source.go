@@ -0,0 +1,80 @@
+package dataproviders
+
+import (
+	"apple-findmy-to-mqtt/core/entities"
+	"apple-findmy-to-mqtt/core/interfaces"
+	"apple-findmy-to-mqtt/infrastructure/config"
+	"apple-findmy-to-mqtt/infrastructure/logging"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"go.uber.org/fx"
+)
+
+type ReplayLocationSourceParams struct {
+	fx.In
+	Config          config.Config
+	FileCacheReader interfaces.IFileCacheReader
+	Logger          logging.Logger
+}
+
+// replayLocationSource replays a recording of FindMy caches: a JSON array of
+// snapshots, each snapshot having the format of a cache file. Every read
+// returns the next snapshot, the last one being returned once the recording
+// is exhausted.
+type replayLocationSource struct {
+	fileCacheReader interfaces.IFileCacheReader
+	logger          logging.Logger
+	mu              sync.Mutex
+	next            int
+	path            string
+	snapshots       [][]FindMyDevice
+}
+
+func NewReplayLocationSource(rlsp ReplayLocationSourceParams) interfaces.ILocationSource {
+	return &replayLocationSource{
+		fileCacheReader: rlsp.FileCacheReader,
+		logger:          rlsp.Logger,
+		path:            rlsp.Config.LocationSources.ReplayPath,
+	}
+}
+
+func (rls *replayLocationSource) Name() string {
+	return "replay"
+}
+
+func (rls *replayLocationSource) ReadDevices() ([]entities.Device, error) {
+	const names = "__replay_location_source.go__: ReadDevices"
+	if rls.path == "" {
+		return nil, nil
+	}
+	rls.mu.Lock()
+	defer rls.mu.Unlock()
+	if rls.snapshots == nil {
+		data, err := os.ReadFile(rls.path)
+		if err != nil {
+			return nil, err
+		}
+		var snapshots [][]FindMyDevice
+		if err := json.Unmarshal(data, &snapshots); err != nil {
+			return nil, fmt.Errorf("%s: %w", rls.path, err)
+		}
+		rls.snapshots = snapshots
+		rls.logger.Info(fmt.Sprintf("%s | Replaying %d snapshots from %s", names, len(snapshots), rls.path))
+	}
+	if len(rls.snapshots) == 0 {
+		return nil, nil
+	}
+
+	snapshot := rls.snapshots[rls.next]
+	if rls.next < len(rls.snapshots)-1 {
+		rls.next++
+	}
+	devices := make([]entities.Device, len(snapshot))
+	for i, findMyDevice := range snapshot {
+		devices[i] = rls.fileCacheReader.ConvertToDevice(findMyDevice)
+	}
+	return devices, nil
+}
@@ -3,6 +3,7 @@ package logging
 import (
 	"apple-findmy-to-mqtt/infrastructure/config"
 	"apple-findmy-to-mqtt/infrastructure/shared"
+	"fmt"
 
 	"go.uber.org/fx/fxevent"
 	"go.uber.org/zap"
@@ -18,6 +19,7 @@ type FxLogger struct {
 }
 
 var (
+	atomicLevel  zap.AtomicLevel
 	globalLogger *Logger
 	zapLogger    *zap.Logger
 )
@@ -147,23 +149,12 @@ func newLogger(config config.Config) Logger {
 	}
 	configZap.OutputPaths = outputPaths
 
-	logLevel := config.LogLevel
-	var level zapcore.Level
-	switch logLevel {
-	case "debug":
-		level = zapcore.DebugLevel
-	case "info":
-		level = zapcore.InfoLevel
-	case "warn":
-		level = zapcore.WarnLevel
-	case "error":
-		level = zapcore.ErrorLevel
-	case "fatal":
-		level = zapcore.FatalLevel
-	default:
+	level, ok := parseLevel(config.LogLevel)
+	if !ok {
 		level = zap.PanicLevel
 	}
 	configZap.Level.SetLevel(level)
+	atomicLevel = configZap.Level
 	zapLogger, err = configZap.Build()
 	if err != nil {
 		panic(err)
@@ -172,3 +163,29 @@ func newLogger(config config.Config) Logger {
 
 	return *logger
 }
+
+func parseLevel(logLevel string) (zapcore.Level, bool) {
+	switch logLevel {
+	case "debug":
+		return zapcore.DebugLevel, true
+	case "info":
+		return zapcore.InfoLevel, true
+	case "warn":
+		return zapcore.WarnLevel, true
+	case "error":
+		return zapcore.ErrorLevel, true
+	case "fatal":
+		return zapcore.FatalLevel, true
+	}
+	return zapcore.InvalidLevel, false
+}
+
+// SetLevel changes the level of the logs written from now on.
+func SetLevel(logLevel string) error {
+	level, ok := parseLevel(logLevel)
+	if !ok {
+		return fmt.Errorf("unknown log level %q, expected debug, info, warn, error or fatal", logLevel)
+	}
+	atomicLevel.SetLevel(level)
+	return nil
+}
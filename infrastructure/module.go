@@ -16,5 +16,14 @@ var Module = fx.Options(
 	fx.Provide(shared.NewHelpers),
 	fx.Provide(adapters.NewPahoMQTTClient),
 	fx.Provide(dataproviders.NewFileCacheReader),
+	fx.Provide(dataproviders.NewCacheWatcher),
+	fx.Provide(dataproviders.NewDeviceIDMigrationFile),
+	fx.Provide(dataproviders.NewDeviceStateStore),
+	fx.Provide(
+		fx.Annotate(dataproviders.NewFileCacheLocationSource, fx.ResultTags(`group:"location_sources"`)),
+		fx.Annotate(dataproviders.NewHTTPPushLocationSource, fx.ResultTags(`group:"location_sources"`)),
+		fx.Annotate(dataproviders.NewReplayLocationSource, fx.ResultTags(`group:"location_sources"`)),
+	),
+	fx.Provide(dataproviders.NewIgnoredDeviceFile),
 	fx.Provide(dataproviders.NewKnownLocationFile),
 )